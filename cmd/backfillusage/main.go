@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/victor-devv/report-gen/config"
+	"github.com/victor-devv/report-gen/storage"
+	"github.com/victor-devv/report-gen/store"
+)
+
+// one off: records the size of report files built before sizes were recorded, so
+// they count towards USER_STORAGE_QUOTA_BYTES. Sizes are read from storage.
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	conf, err := config.New()
+	if err != nil {
+		return err
+	}
+
+	dryRun := flag.Bool("dry-run", false, "print the sizes that would be recorded without changing them")
+	batchSize := flag.Int("batch-size", 100, "number of reports to load at a time")
+	flag.Parse()
+
+	db, err := store.NewPostgresDb(conf)
+	if err != nil {
+		return err
+	}
+	store := store.New(db)
+
+	var s3Client *s3.Client
+	if conf.StorageBackend == storage.BackendS3 {
+		awsConf, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			return err
+		}
+
+		s3Client = s3.NewFromConfig(awsConf, func(options *s3.Options) {
+			if conf.Env != config.Env_Prod {
+				options.BaseEndpoint = aws.String(conf.S3Endpoint)
+				options.UsePathStyle = true
+			}
+		})
+	}
+
+	reportStorage, err := storage.New(conf, s3Client, store.DataKeys)
+	if err != nil {
+		return err
+	}
+
+	updated, missing := 0, 0
+	afterId := uuid.Nil
+	for {
+		page, err := store.Reports.WithoutOutputBytes(ctx, afterId, *batchSize)
+		if err != nil {
+			return err
+		}
+
+		if len(page) == 0 {
+			break
+		}
+
+		for _, report := range page {
+			var outputBytes int64
+			for _, key := range []*string{report.OutputFilePath, report.QuarantineFilePath} {
+				if key == nil {
+					continue
+				}
+
+				info, err := reportStorage.Stat(ctx, *key)
+				if err != nil {
+					if errors.Is(err, storage.ErrNotFound) {
+						log.Printf("skipping missing file %s of report %s", *key, report.Id)
+						missing++
+						continue
+					}
+					return err
+				}
+				outputBytes += info.Size
+			}
+
+			if *dryRun {
+				log.Printf("would record %d bytes for report %s", outputBytes, report.Id)
+				continue
+			}
+
+			if err := store.Reports.SetOutputBytes(ctx, report.UserId, report.Id, outputBytes); err != nil {
+				return err
+			}
+			updated++
+		}
+
+		afterId = page[len(page)-1].Id
+	}
+
+	log.Printf("recorded the size of %d reports, %d files missing", updated, missing)
+	return nil
+}
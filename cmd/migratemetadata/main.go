@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/victor-devv/report-gen/config"
+	"github.com/victor-devv/report-gen/reports"
+	"github.com/victor-devv/report-gen/storage"
+	"github.com/victor-devv/report-gen/store"
+)
+
+// one off: sets the content headers and tags of report files uploaded before the
+// builder set them. Files are rewritten in place, their content does not change.
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	conf, err := config.New()
+	if err != nil {
+		return err
+	}
+
+	dryRun := flag.Bool("dry-run", false, "list the files that would be updated without changing them")
+	batchSize := flag.Int("batch-size", 100, "number of reports to load at a time")
+	flag.Parse()
+
+	db, err := store.NewPostgresDb(conf)
+	if err != nil {
+		return err
+	}
+	store := store.New(db)
+
+	var s3Client *s3.Client
+	if conf.StorageBackend == storage.BackendS3 {
+		awsConf, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			return err
+		}
+
+		s3Client = s3.NewFromConfig(awsConf, func(options *s3.Options) {
+			if conf.Env != config.Env_Prod {
+				options.BaseEndpoint = aws.String(conf.S3Endpoint)
+				options.UsePathStyle = true
+			}
+		})
+	}
+
+	reportStorage, err := storage.New(conf, s3Client, store.DataKeys)
+	if err != nil {
+		return err
+	}
+
+	updated, missing := 0, 0
+	afterId := uuid.Nil
+	for {
+		page, err := store.Reports.WithOutput(ctx, afterId, *batchSize)
+		if err != nil {
+			return err
+		}
+
+		if len(page) == 0 {
+			break
+		}
+
+		for _, report := range page {
+			files := map[string]storage.Metadata{*report.OutputFilePath: reports.OutputMetadata(&report)}
+			if report.QuarantineFilePath != nil {
+				files[*report.QuarantineFilePath] = reports.QuarantineMetadata(&report)
+			}
+
+			for key, metadata := range files {
+				if *dryRun {
+					log.Printf("would update %s", key)
+					continue
+				}
+
+				if err := reportStorage.UpdateMetadata(ctx, key, metadata); err != nil {
+					if errors.Is(err, storage.ErrNotFound) {
+						log.Printf("skipping missing file %s of report %s", key, report.Id)
+						missing++
+						continue
+					}
+					return err
+				}
+				updated++
+			}
+		}
+
+		afterId = page[len(page)-1].Id
+	}
+
+	log.Printf("updated %d files, %d missing", updated, missing)
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/victor-devv/report-gen/config"
+	"github.com/victor-devv/report-gen/queue"
+	"github.com/victor-devv/report-gen/reports"
+	"github.com/victor-devv/report-gen/server"
+	"github.com/victor-devv/report-gen/storage"
+	"github.com/victor-devv/report-gen/store"
+)
+
+// reportgen runs the api and the worker in a single process for local development.
+// Reports are queued in memory and written to STORAGE_DIR, so only postgres is needed.
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	conf, err := config.New()
+	if err != nil {
+		return err
+	}
+
+	if err := conf.ValidateWorker(); err != nil {
+		return err
+	}
+
+	if err := conf.ValidateServer(); err != nil {
+		return err
+	}
+
+	conf.QueueBackend = queue.BackendMemory
+	conf.StorageBackend = storage.BackendFilesystem
+	// the api verifies the urls it signed itself, so any secret works for the lifetime of the process
+	if conf.StorageUrlSecret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return fmt.Errorf("failed to generate storage url secret: %w", err)
+		}
+		conf.StorageUrlSecret = hex.EncodeToString(secret)
+	}
+	if conf.WorkerId == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		conf.WorkerId = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	jsonHandler := slog.NewJSONHandler(os.Stdout, nil)
+	logger := slog.New(jsonHandler)
+
+	db, err := store.NewPostgresDb(conf)
+	if err != nil {
+		return err
+	}
+	store := store.New(db)
+
+	// shared by the api and the worker below, the only setup where an in process queue works
+	messageQueue := queue.NewMemoryQueue(conf.QueueVisibilityTimeout)
+
+	reportStorage, err := storage.New(conf, nil, store.DataKeys)
+	if err != nil {
+		return err
+	}
+
+	httpClient := reports.NewLimitedHttpClient(&http.Client{Timeout: time.Second * 10}, conf.MaxUpstreamBytes)
+
+	var compendium reports.Compendium = reports.NewLozClient(httpClient)
+	if conf.SnapshotDir != "" {
+		logger.Info("using compendium snapshot", "dir", conf.SnapshotDir)
+		compendium = reports.NewSnapshotCompendium(os.DirFS(conf.SnapshotDir))
+	}
+
+	regions, err := reports.LoadRegionTable(conf.RegionTablePath)
+	if err != nil {
+		return err
+	}
+
+	builder := reports.NewReportBuilder(conf, logger, store.Reports, store.DataSources, compendium, regions, httpClient, reportStorage)
+
+	worker := reports.NewWorker(conf, logger.With("worker_id", conf.WorkerId), builder, messageQueue, store.DeadLetters, conf.WorkerConcurrency)
+	worker.Register(reports.NewCacheWarmupJob(compendium))
+	worker.Register(reports.NewRetentionCleanupJob(store.Outbox, conf.OutboxRetention))
+
+	relay := queue.NewOutboxRelay(logger, store.Outbox, messageQueue, conf.OutboxPollInterval)
+	go relay.Run(ctx)
+
+	if conf.ReaperInterval > 0 {
+		reaper := reports.NewReaper(conf, logger, store.Reports)
+		go reaper.Run(ctx)
+	}
+
+	server := server.New(conf, logger, store, server.NewJwtManager(conf), messageQueue, reportStorage)
+
+	// either half failing stops the other, the process exits once both are done
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for _, start := range []func(context.Context) error{server.Start, worker.Start} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := start(ctx); err != nil {
+				errs <- err
+				cancel()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	var runErrs []error
+	for err := range errs {
+		runErrs = append(runErrs, err)
+	}
+
+	return errors.Join(runErrs...)
+}
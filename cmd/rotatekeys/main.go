@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/victor-devv/report-gen/config"
+	"github.com/victor-devv/report-gen/storage"
+	"github.com/victor-devv/report-gen/store"
+)
+
+// rewraps the per user data keys with MASTER_KEY. Set MASTER_KEY to the new key and
+// PREVIOUS_MASTER_KEYS to the old ones, run it, then drop the old keys from config.
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	conf, err := config.New()
+	if err != nil {
+		return err
+	}
+
+	keyring, err := storage.LoadKeyring(conf)
+	if err != nil {
+		return err
+	}
+
+	db, err := store.NewPostgresDb(conf)
+	if err != nil {
+		return err
+	}
+
+	rotated, err := storage.RotateDataKeys(ctx, keyring, store.NewDataKeyStore(db))
+	log.Printf("rewrapped %d data keys with master key %s", rotated, keyring.CurrentId())
+	return err
+}
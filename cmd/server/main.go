@@ -13,7 +13,9 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/victor-devv/report-gen/config"
+	"github.com/victor-devv/report-gen/queue"
 	"github.com/victor-devv/report-gen/server"
+	"github.com/victor-devv/report-gen/storage"
 	"github.com/victor-devv/report-gen/store"
 )
 
@@ -33,6 +35,10 @@ func run() error {
 		return err
 	}
 
+	if err := conf.ValidateServer(); err != nil {
+		return err
+	}
+
 	jsonHandler := slog.NewJSONHandler(os.Stdout, nil)
 	logger := slog.New(jsonHandler)
 
@@ -56,15 +62,26 @@ func run() error {
 		}
 	})
 
-	preSignClient := s3.NewPresignClient(s3Client)
-
 	db, err := store.NewPostgresDb(conf)
 	if err != nil {
 		return err
 	}
 	store := store.New(db)
 
-	server := server.New(conf, logger, store, jwtManager, sqsClient, preSignClient)
+	reportStorage, err := storage.New(conf, s3Client, store.DataKeys)
+	if err != nil {
+		return err
+	}
+
+	messageQueue, err := queue.New(conf, sqsClient, db)
+	if err != nil {
+		return err
+	}
+
+	relay := queue.NewOutboxRelay(logger, store.Outbox, messageQueue, conf.OutboxPollInterval)
+	go relay.Run(ctx)
+
+	server := server.New(conf, logger, store, jwtManager, messageQueue, reportStorage)
 	if err := server.Start(ctx); err != nil {
 		return err
 	}
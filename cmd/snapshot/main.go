@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/victor-devv/report-gen/config"
+	"github.com/victor-devv/report-gen/reports"
+)
+
+// refreshes the compendium snapshot used by offline builds (SNAPSHOT_DIR)
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	conf, err := config.New()
+	if err != nil {
+		return err
+	}
+
+	dir := flag.String("dir", conf.SnapshotDir, "directory to write the snapshot files to")
+	flag.Parse()
+
+	if *dir == "" {
+		return errors.New("a snapshot directory is required, pass -dir or set SNAPSHOT_DIR")
+	}
+
+	lozClient := reports.NewLozClient(&http.Client{Timeout: time.Second * 30})
+	if err := reports.WriteSnapshot(ctx, lozClient, *dir); err != nil {
+		return err
+	}
+
+	log.Printf("snapshot written to %s", *dir)
+	return nil
+}
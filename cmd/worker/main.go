@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
+	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -14,7 +18,9 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/victor-devv/report-gen/config"
+	"github.com/victor-devv/report-gen/queue"
 	"github.com/victor-devv/report-gen/reports"
+	"github.com/victor-devv/report-gen/storage"
 	"github.com/victor-devv/report-gen/store"
 )
 
@@ -25,7 +31,7 @@ func main() {
 }
 
 func run() error {
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
 	conf, err := config.New()
@@ -33,8 +39,21 @@ func run() error {
 		return err
 	}
 
+	if err := conf.ValidateWorker(); err != nil {
+		return err
+	}
+
+	// identifies this worker on the reports it claims
+	if conf.WorkerId == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		conf.WorkerId = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
 	jsonHandler := slog.NewJSONHandler(os.Stdout, nil)
-	logger := slog.New(jsonHandler)
+	logger := slog.New(jsonHandler).With("worker_id", conf.WorkerId)
 
 	db, err := store.NewPostgresDb(conf)
 	if err != nil {
@@ -61,12 +80,51 @@ func run() error {
 		}
 	})
 
-	lozClient := reports.NewLozClient(&http.Client{Timeout: time.Second * 10})
+	httpClient := reports.NewLimitedHttpClient(&http.Client{Timeout: time.Second * 10}, conf.MaxUpstreamBytes)
+
+	var compendium reports.Compendium = reports.NewLozClient(httpClient)
+	if conf.SnapshotDir != "" {
+		logger.Info("using compendium snapshot", "dir", conf.SnapshotDir)
+		compendium = reports.NewSnapshotCompendium(os.DirFS(conf.SnapshotDir))
+	}
+
+	regions, err := reports.LoadRegionTable(conf.RegionTablePath)
+	if err != nil {
+		return err
+	}
+
+	reportStorage, err := storage.New(conf, s3Client, store.DataKeys)
+	if err != nil {
+		return err
+	}
+
+	builder := reports.NewReportBuilder(conf, logger, store.Reports, store.DataSources, compendium, regions, httpClient, reportStorage)
+
+	messageQueue, err := queue.New(conf, sqsClient, db)
+	if err != nil {
+		return err
+	}
 
-	builder := reports.NewReportBuilder(conf, logger, store.Reports, lozClient, s3Client)
+	if conf.MetricsAddr != "" {
+		metricsServer := &http.Server{Addr: conf.MetricsAddr, Handler: expvar.Handler()}
+		go func() {
+			logger.Info("serving metrics", "addr", conf.MetricsAddr)
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("metrics server failed", "error", err)
+			}
+		}()
+		defer metricsServer.Close()
+	}
+
+	// a zero interval disables the reaper, e.g. when it runs in another deployment
+	if conf.ReaperInterval > 0 {
+		reaper := reports.NewReaper(conf, logger, store.Reports)
+		go reaper.Run(ctx)
+	}
 
-	maxConcurrency := 2
-	worker := reports.NewWorker(conf, logger, builder, sqsClient, maxConcurrency)
+	worker := reports.NewWorker(conf, logger, builder, messageQueue, store.DeadLetters, conf.WorkerConcurrency)
+	worker.Register(reports.NewCacheWarmupJob(compendium))
+	worker.Register(reports.NewRetentionCleanupJob(store.Outbox, conf.OutboxRetention))
 
 	if err := worker.Start(ctx); err != nil {
 		return err
@@ -1,7 +1,12 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"strings"
+	"time"
+
 	"github.com/caarlos0/env/v11"
 )
 
@@ -15,21 +20,69 @@ const (
 )
 
 type Config struct {
-	ServerHost       string `env:"SERVER_HOST"`
-	ServerPort       string `env:"SERVER_PORT"`
-	DatabaseName     string `env:"DB_NAME"`
-	DatabaseHost     string `env:"DB_HOST"`
-	DatabasePort     string `env:"DB_PORT"`
-	DatabasePortTest string `env:"DB_PORT_TEST"`
-	DatabaseUser     string `env:"DB_USER"`
-	DatabasePassword string `env:"DB_PASSWORD"`
-	Env              Env    `env:"ENV" envDefault:"dev"`
-	ProjectRoot      string `env:"PROJECT_ROOT"`
-	JwtSecret        string `env:"JWT_SECRET"`
-	S3Endpoint       string `env:"S3_ENDPOINT"`
-	SqsEndpoint      string `env:"SQS_ENDPOINT"`
-	S3Bucket         string `env:"S3_BUCKET"`
-	SqsQueue         string `env:"SQS_QUEUE"`
+	ServerHost             string                   `env:"SERVER_HOST"`
+	ServerPort             string                   `env:"SERVER_PORT"`
+	DatabaseName           string                   `env:"DB_NAME"`
+	DatabaseHost           string                   `env:"DB_HOST"`
+	DatabasePort           string                   `env:"DB_PORT"`
+	DatabasePortTest       string                   `env:"DB_PORT_TEST"`
+	DatabaseUser           string                   `env:"DB_USER"`
+	DatabasePassword       string                   `env:"DB_PASSWORD"`
+	Env                    Env                      `env:"ENV" envDefault:"dev"`
+	ProjectRoot            string                   `env:"PROJECT_ROOT"`
+	JwtSecret              string                   `env:"JWT_SECRET"`
+	S3Endpoint             string                   `env:"S3_ENDPOINT"`
+	SqsEndpoint            string                   `env:"SQS_ENDPOINT"`
+	S3Bucket               string                   `env:"S3_BUCKET"`
+	SqsQueue               string                   `env:"SQS_QUEUE"`
+	AdminEmails            []string                 `env:"ADMIN_EMAILS"`
+	SnapshotDir            string                   `env:"SNAPSHOT_DIR"`
+	RegionTablePath        string                   `env:"REGION_TABLE_PATH"`
+	QueueBackend           string                   `env:"QUEUE_BACKEND" envDefault:"sqs"`
+	QueueName              string                   `env:"QUEUE_NAME" envDefault:"reports"`
+	QueueVisibilityTimeout time.Duration            `env:"QUEUE_VISIBILITY_TIMEOUT" envDefault:"30s"`
+	MaxReceiveCount        int                      `env:"MAX_RECEIVE_COUNT" envDefault:"5"`
+	HeartbeatInterval      time.Duration            `env:"HEARTBEAT_INTERVAL" envDefault:"10s"`
+	MaxBuildRuntime        time.Duration            `env:"MAX_BUILD_RUNTIME" envDefault:"30m"`
+	DefaultBuildTimeout    time.Duration            `env:"DEFAULT_BUILD_TIMEOUT" envDefault:"10s"`
+	BuildTimeouts          map[string]time.Duration `env:"BUILD_TIMEOUTS"`
+	MonstersBuildTimeout   time.Duration            `env:"MONSTERS_BUILD_TIMEOUT" envDefault:"10s"`
+	MaxReportRows          int                      `env:"MAX_REPORT_ROWS" envDefault:"100000"`
+	MaxReportOutputBytes   int64                    `env:"MAX_REPORT_OUTPUT_BYTES" envDefault:"52428800"`
+	MaxUpstreamBytes       int64                    `env:"MAX_UPSTREAM_RESPONSE_BYTES" envDefault:"10485760"`
+	ReceiveWaitTime        time.Duration            `env:"RECEIVE_WAIT_TIME" envDefault:"10s"`
+	WorkerDrainTimeout     time.Duration            `env:"WORKER_DRAIN_TIMEOUT" envDefault:"30s"`
+	WorkerConcurrency      int                      `env:"WORKER_CONCURRENCY" envDefault:"2"`
+	AdaptiveConcurrency    bool                     `env:"WORKER_ADAPTIVE_CONCURRENCY" envDefault:"false"`
+	WorkerMinConcurrency   int                      `env:"WORKER_MIN_CONCURRENCY" envDefault:"1"`
+	WorkerMaxConcurrency   int                      `env:"WORKER_MAX_CONCURRENCY" envDefault:"10"`
+	WorkerScaleInterval    time.Duration            `env:"WORKER_SCALE_INTERVAL" envDefault:"30s"`
+	MaxInFlightPerUser     int                      `env:"MAX_IN_FLIGHT_PER_USER" envDefault:"2"`
+	FairShareDelay         time.Duration            `env:"FAIR_SHARE_DELAY" envDefault:"15s"`
+	OutboxPollInterval     time.Duration            `env:"OUTBOX_POLL_INTERVAL" envDefault:"1s"`
+	ReaperInterval         time.Duration            `env:"REAPER_INTERVAL" envDefault:"1m"`
+	StuckProcessingAfter   time.Duration            `env:"STUCK_PROCESSING_AFTER" envDefault:"45m"`
+	StuckPendingAfter      time.Duration            `env:"STUCK_PENDING_AFTER" envDefault:"1h"`
+	ReaperMaxAttempts      int                      `env:"REAPER_MAX_ATTEMPTS" envDefault:"3"`
+	WorkerId               string                   `env:"WORKER_ID"`
+	OutboxRetention        time.Duration            `env:"OUTBOX_RETENTION" envDefault:"168h"`
+	StorageBackend         string                   `env:"STORAGE_BACKEND" envDefault:"s3"`
+	StorageDir             string                   `env:"STORAGE_DIR" envDefault:"data/reports"`
+	PublicBaseUrl          string                   `env:"PUBLIC_BASE_URL"`
+	StorageUrlSecret       string                   `env:"STORAGE_URL_SECRET"`
+	StorageEncryption      string                   `env:"STORAGE_ENCRYPTION"`
+	StorageReadUnencrypted bool                     `env:"STORAGE_READ_UNENCRYPTED" envDefault:"false"`
+	DownloadMode           string                   `env:"DOWNLOAD_MODE" envDefault:"redirect"`
+	PresignTtl             time.Duration            `env:"PRESIGN_TTL" envDefault:"5m"`
+	MaxPresignTtl          time.Duration            `env:"MAX_PRESIGN_TTL" envDefault:"1h"`
+	UserStorageQuota       int64                    `env:"USER_STORAGE_QUOTA_BYTES" envDefault:"0"`
+	DailyReportQuota       int                      `env:"DAILY_REPORT_QUOTA" envDefault:"0"`
+	MasterKey              string                   `env:"MASTER_KEY"`
+	MasterKeyFile          string                   `env:"MASTER_KEY_FILE"`
+	PreviousMasterKeys     []string                 `env:"PREVIOUS_MASTER_KEYS"`
+	S3KmsKeyId             string                   `env:"S3_KMS_KEY_ID"`
+	S3SseCustomerKey       string                   `env:"S3_SSE_CUSTOMER_KEY"`
+	MetricsAddr            string                   `env:"METRICS_ADDR"`
 }
 
 func (c *Config) DatabaseUrl() string {
@@ -48,6 +101,89 @@ func (c *Config) DatabaseUrl() string {
 	)
 }
 
+// PublicUrl is the url clients reach the api at, PUBLIC_BASE_URL or the listen address.
+func (c *Config) PublicUrl() string {
+	if c.PublicBaseUrl != "" {
+		return c.PublicBaseUrl
+	}
+	return "http://" + net.JoinHostPort(c.ServerHost, c.ServerPort)
+}
+
+func (c *Config) IsAdmin(email string) bool {
+	for _, adminEmail := range c.AdminEmails {
+		if strings.EqualFold(strings.TrimSpace(adminEmail), email) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateServer checks the settings only the api uses, so a bad value stops it
+// from starting instead of handing out urls that expire right away.
+func (c *Config) ValidateServer() error {
+	if c.PresignTtl <= 0 {
+		return fmt.Errorf("PRESIGN_TTL must be positive, got %s", c.PresignTtl)
+	}
+
+	if c.MaxPresignTtl <= 0 {
+		return fmt.Errorf("MAX_PRESIGN_TTL must be positive, got %s", c.MaxPresignTtl)
+	}
+
+	return nil
+}
+
+// ClaimLease is how long a worker's claim keeps other workers off a report. The worker
+// cancels builds running longer than MaxBuildRuntime, so past that the claimant is gone.
+func (c *Config) ClaimLease() time.Duration {
+	if c.MaxBuildRuntime > 0 {
+		return c.MaxBuildRuntime
+	}
+	return c.StuckProcessingAfter
+}
+
+// ValidateWorker checks the settings only the worker uses, so a bad value stops it
+// from starting instead of leaving it without consumers.
+func (c *Config) ValidateWorker() error {
+	if c.WorkerConcurrency < 1 {
+		return fmt.Errorf("WORKER_CONCURRENCY must be at least 1, got %d", c.WorkerConcurrency)
+	}
+
+	// the message would become visible and be redelivered between two heartbeats
+	if c.HeartbeatInterval > 0 && c.HeartbeatInterval >= c.QueueVisibilityTimeout {
+		return fmt.Errorf("HEARTBEAT_INTERVAL %s must be shorter than QUEUE_VISIBILITY_TIMEOUT %s", c.HeartbeatInterval, c.QueueVisibilityTimeout)
+	}
+
+	lease := c.ClaimLease()
+	if lease <= 0 {
+		return errors.New("MAX_BUILD_RUNTIME or STUCK_PROCESSING_AFTER must be positive, they bound report claims")
+	}
+
+	// a build running past its claim can be claimed and built again by another worker
+	timeouts := map[string]time.Duration{
+		"DEFAULT_BUILD_TIMEOUT":  c.DefaultBuildTimeout,
+		"MONSTERS_BUILD_TIMEOUT": c.MonstersBuildTimeout,
+	}
+	for reportType, timeout := range c.BuildTimeouts {
+		timeouts["BUILD_TIMEOUTS "+reportType] = timeout
+	}
+	for name, timeout := range timeouts {
+		if timeout > lease {
+			return fmt.Errorf("%s %s exceeds the report claim lease of %s", name, timeout, lease)
+		}
+	}
+
+	if c.AdaptiveConcurrency {
+		if c.WorkerMinConcurrency < 1 {
+			return fmt.Errorf("WORKER_MIN_CONCURRENCY must be at least 1, got %d", c.WorkerMinConcurrency)
+		}
+		if c.WorkerMinConcurrency > c.WorkerMaxConcurrency {
+			return fmt.Errorf("WORKER_MIN_CONCURRENCY %d must not exceed WORKER_MAX_CONCURRENCY %d", c.WorkerMinConcurrency, c.WorkerMaxConcurrency)
+		}
+	}
+
+	return nil
+}
+
 func New() (*Config, error) {
 	cfg, err := env.ParseAs[Config]()
 	if err != nil {
@@ -0,0 +1,60 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/config"
+)
+
+func TestValidateWorker(t *testing.T) {
+	valid := func() *config.Config {
+		return &config.Config{
+			WorkerConcurrency:      2,
+			HeartbeatInterval:      10 * time.Second,
+			QueueVisibilityTimeout: 30 * time.Second,
+			MaxBuildRuntime:        30 * time.Minute,
+			StuckProcessingAfter:   45 * time.Minute,
+			DefaultBuildTimeout:    10 * time.Second,
+			MonstersBuildTimeout:   10 * time.Second,
+			BuildTimeouts:          map[string]time.Duration{"weather": time.Minute},
+		}
+	}
+	require.NoError(t, valid().ValidateWorker())
+
+	for name, invalidate := range map[string]func(*config.Config){
+		"no consumers": func(c *config.Config) { c.WorkerConcurrency = 0 },
+		"min above max": func(c *config.Config) {
+			c.AdaptiveConcurrency, c.WorkerMinConcurrency, c.WorkerMaxConcurrency = true, 5, 2
+		},
+		"build outlives lease": func(c *config.Config) { c.BuildTimeouts["weather"] = time.Hour },
+		"heartbeat too slow":   func(c *config.Config) { c.HeartbeatInterval = c.QueueVisibilityTimeout },
+		"no lease":             func(c *config.Config) { c.MaxBuildRuntime, c.StuckProcessingAfter = 0, 0 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			conf := valid()
+			invalidate(conf)
+			require.Error(t, conf.ValidateWorker())
+		})
+	}
+}
+
+func TestClaimLease(t *testing.T) {
+	conf := &config.Config{MaxBuildRuntime: 30 * time.Minute, StuckProcessingAfter: 45 * time.Minute}
+	require.Equal(t, 30*time.Minute, conf.ClaimLease())
+
+	conf.MaxBuildRuntime = 0
+	require.Equal(t, 45*time.Minute, conf.ClaimLease())
+}
+
+func TestValidateServer(t *testing.T) {
+	conf := &config.Config{PresignTtl: 5 * time.Minute, MaxPresignTtl: time.Hour}
+	require.NoError(t, conf.ValidateServer())
+
+	conf.MaxPresignTtl = 0
+	require.Error(t, conf.ValidateServer())
+
+	conf.MaxPresignTtl, conf.PresignTtl = time.Hour, 0
+	require.Error(t, conf.ValidateServer())
+}
@@ -54,6 +54,11 @@ func (te *TestEnv) TeardownDb(t *testing.T) {
 		"users",
 		"refresh_tokens",
 		"reports",
+		"data_sources",
+		"jobs",
+		"dead_letters",
+		"outbox",
+		"user_data_keys",
 	}, ", ")))
 	require.NoError(t, err)
 }
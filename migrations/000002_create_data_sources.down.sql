@@ -0,0 +1 @@
+DROP TABLE IF EXISTS data_sources;
\ No newline at end of file
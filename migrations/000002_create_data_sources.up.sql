@@ -0,0 +1,12 @@
+CREATE TABLE data_sources (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR NOT NULL UNIQUE,
+    base_url VARCHAR NOT NULL,
+    path VARCHAR NOT NULL DEFAULT '',
+    query_params JSONB NOT NULL DEFAULT '{}',
+    auth_header_name VARCHAR,
+    auth_header_value VARCHAR,
+    records_path VARCHAR NOT NULL DEFAULT '$',
+    field_mapping JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+ALTER TABLE reports DROP COLUMN IF EXISTS options;
\ No newline at end of file
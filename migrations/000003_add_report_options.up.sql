@@ -0,0 +1 @@
+ALTER TABLE reports ADD COLUMN options JSONB NOT NULL DEFAULT '{}';
\ No newline at end of file
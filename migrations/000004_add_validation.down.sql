@@ -0,0 +1,7 @@
+ALTER TABLE reports
+    DROP COLUMN IF EXISTS quarantined_rows,
+    DROP COLUMN IF EXISTS quarantine_file_path;
+
+ALTER TABLE data_sources
+    DROP COLUMN IF EXISTS assertions,
+    DROP COLUMN IF EXISTS validation;
\ No newline at end of file
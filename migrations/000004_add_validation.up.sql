@@ -0,0 +1,7 @@
+ALTER TABLE data_sources
+    ADD COLUMN validation JSONB NOT NULL DEFAULT '{}',
+    ADD COLUMN assertions JSONB NOT NULL DEFAULT '{}';
+
+ALTER TABLE reports
+    ADD COLUMN quarantine_file_path VARCHAR,
+    ADD COLUMN quarantined_rows INTEGER NOT NULL DEFAULT 0;
\ No newline at end of file
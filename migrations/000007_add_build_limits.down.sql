@@ -0,0 +1,3 @@
+ALTER TABLE reports DROP COLUMN IF EXISTS error_code;
+
+ALTER TABLE data_sources DROP COLUMN IF EXISTS timeout_seconds;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE data_sources ADD COLUMN timeout_seconds INTEGER;
+
+ALTER TABLE reports ADD COLUMN error_code VARCHAR;
\ No newline at end of file
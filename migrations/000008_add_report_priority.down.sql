@@ -0,0 +1 @@
+ALTER TABLE reports DROP COLUMN IF EXISTS priority;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE reports ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
\ No newline at end of file
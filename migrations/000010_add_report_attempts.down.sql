@@ -0,0 +1,3 @@
+ALTER TABLE reports DROP COLUMN IF EXISTS enqueued_at;
+
+ALTER TABLE reports DROP COLUMN IF EXISTS attempts;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE reports ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE reports ADD COLUMN enqueued_at TIMESTAMPTZ;
\ No newline at end of file
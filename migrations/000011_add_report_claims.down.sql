@@ -0,0 +1,3 @@
+ALTER TABLE reports DROP COLUMN IF EXISTS lease_expires_at;
+
+ALTER TABLE reports DROP COLUMN IF EXISTS claimed_by;
\ No newline at end of file
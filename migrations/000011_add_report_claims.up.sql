@@ -0,0 +1,3 @@
+ALTER TABLE reports ADD COLUMN claimed_by VARCHAR;
+
+ALTER TABLE reports ADD COLUMN lease_expires_at TIMESTAMPTZ;
\ No newline at end of file
@@ -0,0 +1,9 @@
+CREATE TABLE user_data_keys (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    wrapped_key BYTEA NOT NULL,
+    master_key_id VARCHAR NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    rotated_at TIMESTAMPTZ
+);
+
+CREATE INDEX user_data_keys_master_key_idx ON user_data_keys (master_key_id);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE reports ADD COLUMN download_url VARCHAR;
+
+ALTER TABLE reports ADD COLUMN download_url_expires_at TIMESTAMPTZ;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE reports DROP COLUMN IF EXISTS download_url;
+
+ALTER TABLE reports DROP COLUMN IF EXISTS download_url_expires_at;
\ No newline at end of file
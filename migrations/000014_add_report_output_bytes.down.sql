@@ -0,0 +1 @@
+ALTER TABLE reports DROP COLUMN IF EXISTS output_bytes;
\ No newline at end of file
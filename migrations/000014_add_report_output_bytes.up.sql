@@ -0,0 +1 @@
+ALTER TABLE reports ADD COLUMN output_bytes BIGINT NOT NULL DEFAULT 0;
\ No newline at end of file
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS reports_labels_idx;
+
+DROP INDEX IF EXISTS reports_user_type_created_at_idx;
+
+DROP INDEX IF EXISTS reports_user_created_at_idx;
+
+ALTER TABLE reports DROP COLUMN IF EXISTS labels;
\ No newline at end of file
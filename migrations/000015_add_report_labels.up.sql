@@ -0,0 +1,7 @@
+ALTER TABLE reports ADD COLUMN labels TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX reports_user_created_at_idx ON reports (user_id, created_at, id);
+
+CREATE INDEX reports_user_type_created_at_idx ON reports (user_id, report_type, created_at, id);
+
+CREATE INDEX reports_labels_idx ON reports USING GIN (labels);
\ No newline at end of file
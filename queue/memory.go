@@ -0,0 +1,193 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type memoryMessage struct {
+	Message
+	visibleAt time.Time
+}
+
+// MemoryQueue is an in process queue for tests and single process deployments.
+// Messages are lost when the process exits.
+type MemoryQueue struct {
+	visibilityTimeout time.Duration
+
+	mu       sync.Mutex
+	messages []*memoryMessage
+	nextId   int
+	// closed and replaced whenever a message becomes visible so waiting receivers wake up
+	changed chan struct{}
+}
+
+func NewMemoryQueue(visibilityTimeout time.Duration) *MemoryQueue {
+	return &MemoryQueue{
+		visibilityTimeout: visibilityTimeout,
+		changed:           make(chan struct{}),
+	}
+}
+
+func (q *MemoryQueue) Send(ctx context.Context, body string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.nextId++
+	q.messages = append(q.messages, &memoryMessage{
+		Message:   Message{Id: strconv.Itoa(q.nextId), Body: body},
+		visibleAt: time.Now(),
+	})
+	q.notify()
+
+	return nil
+}
+
+func (q *MemoryQueue) Receive(ctx context.Context, maxMessages int, wait time.Duration) ([]Message, error) {
+	deadline := time.Now().Add(wait)
+
+	for {
+		messages, changed, nextVisibleAt := q.receive(max(maxMessages, 1))
+		if len(messages) > 0 || !time.Now().Before(deadline) {
+			return messages, nil
+		}
+
+		wakeAt := deadline
+		if !nextVisibleAt.IsZero() && nextVisibleAt.Before(wakeAt) {
+			wakeAt = nextVisibleAt
+		}
+
+		timer := time.NewTimer(time.Until(wakeAt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// receive hides and returns up to max visible messages. It also returns the channel
+// signalling new messages and when the next hidden message becomes visible.
+func (q *MemoryQueue) receive(max int) ([]Message, chan struct{}, time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var messages []Message
+	var nextVisibleAt time.Time
+
+	for _, message := range q.messages {
+		if message.visibleAt.After(now) {
+			if nextVisibleAt.IsZero() || message.visibleAt.Before(nextVisibleAt) {
+				nextVisibleAt = message.visibleAt
+			}
+			continue
+		}
+
+		if len(messages) == max {
+			break
+		}
+
+		message.ReceiveCount++
+		message.ReceiptHandle = uuid.NewString()
+		message.visibleAt = now.Add(q.visibilityTimeout)
+		messages = append(messages, message.Message)
+	}
+
+	return messages, q.changed, nextVisibleAt
+}
+
+func (q *MemoryQueue) Ack(ctx context.Context, message Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i, err := q.held(message)
+	if err != nil {
+		return err
+	}
+
+	q.messages = append(q.messages[:i], q.messages[i+1:]...)
+	return nil
+}
+
+func (q *MemoryQueue) Nack(ctx context.Context, message Message, delay time.Duration) error {
+	if err := q.ExtendVisibility(ctx, message, delay); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.notify()
+
+	return nil
+}
+
+func (q *MemoryQueue) Defer(ctx context.Context, message Message, delay time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i, err := q.held(message)
+	if err != nil {
+		return err
+	}
+
+	q.messages[i].ReceiveCount--
+	q.messages[i].visibleAt = time.Now().Add(delay)
+	q.notify()
+
+	return nil
+}
+
+func (q *MemoryQueue) ExtendVisibility(ctx context.Context, message Message, timeout time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i, err := q.held(message)
+	if err != nil {
+		return err
+	}
+
+	q.messages[i].visibleAt = time.Now().Add(timeout)
+	return nil
+}
+
+func (q *MemoryQueue) Depth(ctx context.Context) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	depth := 0
+	for _, message := range q.messages {
+		if !message.visibleAt.After(now) {
+			depth++
+		}
+	}
+
+	return depth, nil
+}
+
+// held returns the index of message if the receipt handle is still current. Must be called with mu held.
+func (q *MemoryQueue) held(message Message) (int, error) {
+	for i, m := range q.messages {
+		if m.Id == message.Id {
+			if m.ReceiptHandle != message.ReceiptHandle || !m.visibleAt.After(time.Now()) {
+				return 0, ErrMessageNotHeld
+			}
+			return i, nil
+		}
+	}
+	return 0, ErrMessageNotHeld
+}
+
+// notify wakes up waiting receivers. Must be called with mu held.
+func (q *MemoryQueue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
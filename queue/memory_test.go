@@ -0,0 +1,86 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/queue"
+)
+
+func TestMemoryQueue(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(time.Minute)
+
+	require.NoError(t, q.Send(ctx, "first"))
+	require.NoError(t, q.Send(ctx, "second"))
+
+	depth, err := q.Depth(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, depth)
+
+	messages, err := q.Receive(ctx, 1, 0)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, "first", messages[0].Body)
+
+	// held messages don't count towards the depth
+	depth, err = q.Depth(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, depth)
+	require.Equal(t, 1, messages[0].ReceiveCount)
+
+	// received messages are hidden from other receivers
+	messages2, err := q.Receive(ctx, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, messages2, 1)
+	require.Equal(t, "second", messages2[0].Body)
+
+	require.NoError(t, q.Ack(ctx, messages2[0]))
+	require.ErrorIs(t, q.Ack(ctx, messages2[0]), queue.ErrMessageNotHeld)
+
+	// nacked messages are redelivered with a new receipt handle
+	require.NoError(t, q.Nack(ctx, messages[0], 0))
+	redelivered, err := q.Receive(ctx, 10, time.Second)
+	require.NoError(t, err)
+	require.Len(t, redelivered, 1)
+	require.Equal(t, 2, redelivered[0].ReceiveCount)
+	require.ErrorIs(t, q.ExtendVisibility(ctx, messages[0], time.Minute), queue.ErrMessageNotHeld)
+	require.NoError(t, q.ExtendVisibility(ctx, redelivered[0], time.Minute))
+
+	// deferred messages come back without the deferral counting as a receive
+	require.NoError(t, q.Defer(ctx, redelivered[0], 0))
+	deferred, err := q.Receive(ctx, 10, time.Second)
+	require.NoError(t, err)
+	require.Len(t, deferred, 1)
+	require.Equal(t, 2, deferred[0].ReceiveCount)
+	require.NoError(t, q.ExtendVisibility(ctx, deferred[0], time.Minute))
+
+	// long polling returns as soon as a message is sent
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		q.Send(ctx, "third")
+	}()
+	start := time.Now()
+	messages3, err := q.Receive(ctx, 10, 5*time.Second)
+	require.NoError(t, err)
+	require.Len(t, messages3, 1)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestMemoryQueueVisibilityTimeout(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(50 * time.Millisecond)
+
+	require.NoError(t, q.Send(ctx, "body"))
+	messages, err := q.Receive(ctx, 1, 0)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+
+	redelivered, err := q.Receive(ctx, 1, time.Second)
+	require.NoError(t, err)
+	require.Len(t, redelivered, 1)
+	require.Equal(t, messages[0].Id, redelivered[0].Id)
+	require.ErrorIs(t, q.Ack(ctx, messages[0]), queue.ErrMessageNotHeld)
+}
@@ -0,0 +1,122 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/victor-devv/report-gen/store"
+)
+
+// how often an empty postgres queue is polled while waiting for messages
+const postgresPollInterval = 500 * time.Millisecond
+
+// PostgresQueue stores messages in the jobs table so small deployments can run without sqs.
+type PostgresQueue struct {
+	jobStore          *store.JobStore
+	name              string
+	visibilityTimeout time.Duration
+}
+
+func NewPostgresQueue(jobStore *store.JobStore, name string, visibilityTimeout time.Duration) *PostgresQueue {
+	return &PostgresQueue{
+		jobStore:          jobStore,
+		name:              name,
+		visibilityTimeout: visibilityTimeout,
+	}
+}
+
+func (q *PostgresQueue) Send(ctx context.Context, body string) error {
+	_, err := q.jobStore.Enqueue(ctx, q.name, body, 0)
+	return err
+}
+
+func (q *PostgresQueue) Receive(ctx context.Context, maxMessages int, wait time.Duration) ([]Message, error) {
+	deadline := time.Now().Add(wait)
+
+	for {
+		jobs, err := q.jobStore.Dequeue(ctx, q.name, max(maxMessages, 1), q.visibilityTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(jobs) > 0 || !time.Now().Before(deadline) {
+			messages := make([]Message, 0, len(jobs))
+			for _, job := range jobs {
+				messages = append(messages, Message{
+					Id:            strconv.FormatInt(job.Id, 10),
+					Body:          job.Body,
+					ReceiptHandle: job.ReceiptHandle.String(),
+					ReceiveCount:  job.ReceiveCount,
+				})
+			}
+			return messages, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(min(postgresPollInterval, time.Until(deadline))):
+		}
+	}
+}
+
+func (q *PostgresQueue) Ack(ctx context.Context, message Message) error {
+	id, receiptHandle, err := parsePostgresMessage(message)
+	if err != nil {
+		return err
+	}
+
+	return mapPostgresError(q.jobStore.Delete(ctx, id, receiptHandle))
+}
+
+func (q *PostgresQueue) Nack(ctx context.Context, message Message, delay time.Duration) error {
+	return q.ExtendVisibility(ctx, message, delay)
+}
+
+func (q *PostgresQueue) Defer(ctx context.Context, message Message, delay time.Duration) error {
+	id, receiptHandle, err := parsePostgresMessage(message)
+	if err != nil {
+		return err
+	}
+
+	return mapPostgresError(q.jobStore.Defer(ctx, id, receiptHandle, delay))
+}
+
+func (q *PostgresQueue) ExtendVisibility(ctx context.Context, message Message, timeout time.Duration) error {
+	id, receiptHandle, err := parsePostgresMessage(message)
+	if err != nil {
+		return err
+	}
+
+	return mapPostgresError(q.jobStore.SetVisibility(ctx, id, receiptHandle, timeout))
+}
+
+func (q *PostgresQueue) Depth(ctx context.Context) (int, error) {
+	return q.jobStore.CountVisible(ctx, q.name)
+}
+
+func parsePostgresMessage(message Message) (int64, uuid.UUID, error) {
+	id, err := strconv.ParseInt(message.Id, 10, 64)
+	if err != nil {
+		return 0, uuid.Nil, fmt.Errorf("invalid message id %s: %w", message.Id, err)
+	}
+
+	receiptHandle, err := uuid.Parse(message.ReceiptHandle)
+	if err != nil {
+		return 0, uuid.Nil, fmt.Errorf("invalid receipt handle for message %s: %w", message.Id, err)
+	}
+
+	return id, receiptHandle, nil
+}
+
+func mapPostgresError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrMessageNotHeld
+	}
+	return err
+}
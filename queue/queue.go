@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/victor-devv/report-gen/config"
+	"github.com/victor-devv/report-gen/store"
+)
+
+const (
+	BackendSqs      = "sqs"
+	BackendPostgres = "postgres"
+	BackendMemory   = "memory"
+)
+
+// ErrMessageNotHeld is returned when acking or extending a message whose
+// visibility timeout has expired, i.e. it may now be held by another consumer.
+var ErrMessageNotHeld = errors.New("message is no longer held by this receiver")
+
+type Message struct {
+	Id            string
+	Body          string
+	ReceiptHandle string
+	ReceiveCount  int
+}
+
+// Queue is an at least once delivery queue. Received messages are hidden from other
+// receivers until they are acked, nacked or their visibility timeout expires.
+type Queue interface {
+	Send(ctx context.Context, body string) error
+	// Receive waits up to wait for at least one message and returns at most maxMessages.
+	Receive(ctx context.Context, maxMessages int, wait time.Duration) ([]Message, error)
+	Ack(ctx context.Context, message Message) error
+	// Nack makes the message visible to receivers again after delay.
+	Nack(ctx context.Context, message Message, delay time.Duration) error
+	// Defer is Nack for a message that was put back without being processed, the
+	// delivery does not count towards its ReceiveCount.
+	Defer(ctx context.Context, message Message, delay time.Duration) error
+	ExtendVisibility(ctx context.Context, message Message, timeout time.Duration) error
+}
+
+// DepthReporter is implemented by queues that can report how many messages are
+// waiting to be received. Messages currently held by receivers are not counted.
+type DepthReporter interface {
+	Depth(ctx context.Context) (int, error)
+}
+
+// New builds the queue selected by QUEUE_BACKEND. sqsClient is only required for
+// the sqs backend and db only for the postgres backend. The memory backend is rejected,
+// the reportgen command builds its memory queue itself.
+func New(conf *config.Config, sqsClient *sqs.Client, db *sql.DB) (Queue, error) {
+	switch conf.QueueBackend {
+	case BackendSqs:
+		if sqsClient == nil {
+			return nil, errors.New("the sqs queue backend requires an sqs client")
+		}
+		return NewSqsQueue(sqsClient, conf.SqsQueue, conf.QueueVisibilityTimeout), nil
+	case BackendPostgres:
+		if db == nil {
+			return nil, errors.New("the postgres queue backend requires a database")
+		}
+		return NewPostgresQueue(store.NewJobStore(db), conf.QueueName, conf.QueueVisibilityTimeout), nil
+	case BackendMemory:
+		// a separate api and worker would each get their own queue and no report would ever be built
+		return nil, errors.New("the memory queue backend only works within a single process, use the reportgen command")
+	}
+
+	return nil, fmt.Errorf("unknown queue backend %q", conf.QueueBackend)
+}
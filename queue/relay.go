@@ -0,0 +1,102 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/victor-devv/report-gen/store"
+)
+
+const (
+	outboxBatchSize = 100
+	// how long a claimed batch is hidden from other relays, it must outlast sending it
+	outboxLease     = 30 * time.Second
+	outboxBaseDelay = time.Second
+	outboxMaxDelay  = 5 * time.Minute
+)
+
+// OutboxRelay publishes outbox messages to the queue and marks them sent. Failed
+// sends are retried with exponential backoff, so every message is sent at least once.
+type OutboxRelay struct {
+	logger       *slog.Logger
+	outboxStore  *store.OutboxStore
+	queue        Queue
+	pollInterval time.Duration
+}
+
+func NewOutboxRelay(logger *slog.Logger, outboxStore *store.OutboxStore, messageQueue Queue, pollInterval time.Duration) *OutboxRelay {
+	return &OutboxRelay{
+		logger:       logger,
+		outboxStore:  outboxStore,
+		queue:        messageQueue,
+		pollInterval: pollInterval,
+	}
+}
+
+// Run relays messages every poll interval until ctx is done.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	r.logger.Info("starting outbox relay", "poll_interval", r.pollInterval.String())
+
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// keep going while full batches come back, there is a backlog to work through
+		for {
+			relayed, err := r.relay(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					r.logger.Error("failed to relay outbox messages", "error", err)
+				}
+				break
+			}
+			if relayed < outboxBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			r.logger.Info("stopping outbox relay")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay publishes one batch and returns how many messages it claimed.
+func (r *OutboxRelay) relay(ctx context.Context) (int, error) {
+	messages, err := r.outboxStore.Claim(ctx, outboxBatchSize, outboxLease)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, message := range messages {
+		if err := r.queue.Send(ctx, message.Body); err != nil {
+			retryIn := outboxBackoff(message.Attempts)
+			r.logger.Warn("failed to send outbox message", "outbox_id", message.Id, "attempts", message.Attempts, "retry_in", retryIn.String(), "error", err)
+			if err := r.outboxStore.MarkFailed(ctx, message.Id, err.Error(), retryIn); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		if err := r.outboxStore.MarkSent(ctx, message.Id); err != nil {
+			// the lease expires and the message is sent again, consumers tolerate duplicates
+			return 0, err
+		}
+	}
+
+	return len(messages), nil
+}
+
+// outboxBackoff doubles the retry delay with every failed attempt, up to outboxMaxDelay.
+func outboxBackoff(attempts int) time.Duration {
+	if shift := attempts - 1; shift >= 0 && shift < 32 {
+		if delay := outboxBaseDelay << shift; delay > 0 && delay < outboxMaxDelay {
+			return delay
+		}
+	}
+	return outboxMaxDelay
+}
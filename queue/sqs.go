@@ -0,0 +1,198 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// sqs caps a single ReceiveMessage call at 10 messages and 20 seconds of long polling,
+// and delays messages by at most 15 minutes
+const (
+	sqsMaxMessages = 10
+	sqsMaxWait     = 20 * time.Second
+	sqsMaxDelay    = 15 * time.Minute
+)
+
+type SqsQueue struct {
+	client            *sqs.Client
+	name              string
+	visibilityTimeout time.Duration
+
+	mu  sync.Mutex
+	url *string
+}
+
+func NewSqsQueue(client *sqs.Client, name string, visibilityTimeout time.Duration) *SqsQueue {
+	return &SqsQueue{
+		client:            client,
+		name:              name,
+		visibilityTimeout: visibilityTimeout,
+	}
+}
+
+// queueUrl resolves the queue url once and caches it for subsequent calls.
+func (q *SqsQueue) queueUrl(ctx context.Context) (*string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.url != nil {
+		return q.url, nil
+	}
+
+	output, err := q.client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+		QueueName: aws.String(q.name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get url for queue %s: %w", q.name, err)
+	}
+
+	q.url = output.QueueUrl
+	return q.url, nil
+}
+
+func (q *SqsQueue) Send(ctx context.Context, body string) error {
+	queueUrl, err := q.queueUrl(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    queueUrl,
+		MessageBody: aws.String(body),
+	}); err != nil {
+		return fmt.Errorf("failed to send message to queue %s: %w", q.name, err)
+	}
+
+	return nil
+}
+
+func (q *SqsQueue) Receive(ctx context.Context, maxMessages int, wait time.Duration) ([]Message, error) {
+	queueUrl, err := q.queueUrl(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := q.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:                    queueUrl,
+		MaxNumberOfMessages:         int32(min(max(maxMessages, 1), sqsMaxMessages)),
+		WaitTimeSeconds:             int32(min(wait, sqsMaxWait).Seconds()),
+		VisibilityTimeout:           int32(q.visibilityTimeout.Seconds()),
+		MessageSystemAttributeNames: []types.MessageSystemAttributeName{types.MessageSystemAttributeNameApproximateReceiveCount},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive messages from queue %s: %w", q.name, err)
+	}
+
+	messages := make([]Message, 0, len(output.Messages))
+	for _, message := range output.Messages {
+		receiveCount, _ := strconv.Atoi(message.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+		messages = append(messages, Message{
+			Id:            aws.ToString(message.MessageId),
+			Body:          aws.ToString(message.Body),
+			ReceiptHandle: aws.ToString(message.ReceiptHandle),
+			ReceiveCount:  receiveCount,
+		})
+	}
+
+	return messages, nil
+}
+
+func (q *SqsQueue) Ack(ctx context.Context, message Message) error {
+	queueUrl, err := q.queueUrl(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      queueUrl,
+		ReceiptHandle: aws.String(message.ReceiptHandle),
+	}); err != nil {
+		return fmt.Errorf("failed to delete message %s: %w", message.Id, mapSqsError(err))
+	}
+
+	return nil
+}
+
+func (q *SqsQueue) Nack(ctx context.Context, message Message, delay time.Duration) error {
+	return q.changeVisibility(ctx, message, delay)
+}
+
+// Defer sends a copy of message delayed by delay and deletes the original, as sqs
+// can't take back a receive. The delay is capped at the sqs maximum of 15 minutes.
+func (q *SqsQueue) Defer(ctx context.Context, message Message, delay time.Duration) error {
+	queueUrl, err := q.queueUrl(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:     queueUrl,
+		MessageBody:  aws.String(message.Body),
+		DelaySeconds: int32(min(delay, sqsMaxDelay).Seconds()),
+	}); err != nil {
+		return fmt.Errorf("failed to send deferred copy of message %s: %w", message.Id, err)
+	}
+
+	return q.Ack(ctx, message)
+}
+
+func (q *SqsQueue) ExtendVisibility(ctx context.Context, message Message, timeout time.Duration) error {
+	return q.changeVisibility(ctx, message, timeout)
+}
+
+func (q *SqsQueue) changeVisibility(ctx context.Context, message Message, timeout time.Duration) error {
+	queueUrl, err := q.queueUrl(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          queueUrl,
+		ReceiptHandle:     aws.String(message.ReceiptHandle),
+		VisibilityTimeout: int32(timeout.Seconds()),
+	}); err != nil {
+		return fmt.Errorf("failed to change visibility of message %s: %w", message.Id, mapSqsError(err))
+	}
+
+	return nil
+}
+
+func (q *SqsQueue) Depth(ctx context.Context) (int, error) {
+	queueUrl, err := q.queueUrl(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	attribute := types.QueueAttributeNameApproximateNumberOfMessages
+	output, err := q.client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       queueUrl,
+		AttributeNames: []types.QueueAttributeName{attribute},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get attributes of queue %s: %w", q.name, err)
+	}
+
+	depth, err := strconv.Atoi(output.Attributes[string(attribute)])
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s for queue %s: %w", attribute, q.name, err)
+	}
+
+	return depth, nil
+}
+
+func mapSqsError(err error) error {
+	var receiptHandleErr *types.ReceiptHandleIsInvalid
+	var notInFlightErr *types.MessageNotInflight
+	if errors.As(err, &receiptHandleErr) || errors.As(err, &notInFlightErr) {
+		return errors.Join(ErrMessageNotHeld, err)
+	}
+	return err
+}
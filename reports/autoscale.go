@@ -0,0 +1,75 @@
+package reports
+
+import (
+	"expvar"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	workerConcurrency  = expvar.NewInt("worker_concurrency")
+	workerQueueDepth   = expvar.NewInt("worker_queue_depth")
+	workerBuildLatency = expvar.NewInt("worker_build_latency_ms")
+	workerScaleUps     = expvar.NewInt("worker_scale_ups")
+	workerScaleDowns   = expvar.NewInt("worker_scale_downs")
+)
+
+// Autoscaler sizes the worker pool between Min and Max goroutines.
+type Autoscaler struct {
+	Min      int
+	Max      int
+	Interval time.Duration
+}
+
+// Desired returns the pool size for the next Interval given the number of waiting
+// messages and the average time spent on one. The pool grows straight to the size
+// needed to clear the backlog within one Interval but only shrinks one goroutine at
+// a time so a short lull doesn't tear the pool down.
+func (a Autoscaler) Desired(current, depth int, latency time.Duration) int {
+	desired := current
+
+	switch {
+	case depth == 0:
+		desired = current - 1
+	case latency <= 0 || a.Interval <= 0:
+		// nothing measured yet, grow one step at a time
+		desired = current + 1
+	default:
+		needed := int(math.Ceil(float64(depth) * latency.Seconds() / a.Interval.Seconds()))
+		if needed > current {
+			desired = needed
+		} else if needed < current {
+			desired = current - 1
+		}
+	}
+
+	return min(max(desired, a.Min), a.Max)
+}
+
+// latencyTracker keeps an exponentially weighted moving average of build latency.
+type latencyTracker struct {
+	mu      sync.Mutex
+	average time.Duration
+}
+
+// weight of the latest observation in the moving average
+const latencySmoothing = 0.2
+
+func (l *latencyTracker) Observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.average == 0 {
+		l.average = d
+	} else {
+		l.average = time.Duration(latencySmoothing*float64(d) + (1-latencySmoothing)*float64(l.average))
+	}
+	workerBuildLatency.Set(l.average.Milliseconds())
+}
+
+func (l *latencyTracker) Average() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.average
+}
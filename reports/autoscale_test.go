@@ -0,0 +1,35 @@
+package reports_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/reports"
+)
+
+func TestAutoscalerDesired(t *testing.T) {
+	autoscaler := reports.Autoscaler{Min: 1, Max: 10, Interval: 30 * time.Second}
+
+	testCases := []struct {
+		name     string
+		current  int
+		depth    int
+		latency  time.Duration
+		expected int
+	}{
+		{name: "empty queue shrinks by one", current: 4, depth: 0, latency: time.Second, expected: 3},
+		{name: "never below min", current: 1, depth: 0, latency: time.Second, expected: 1},
+		{name: "no latency yet grows by one", current: 2, depth: 50, latency: 0, expected: 3},
+		{name: "grows to clear backlog within interval", current: 2, depth: 60, latency: 3 * time.Second, expected: 6},
+		{name: "never above max", current: 2, depth: 1000, latency: 10 * time.Second, expected: 10},
+		{name: "keeps size when it matches the backlog", current: 6, depth: 60, latency: 3 * time.Second, expected: 6},
+		{name: "small backlog shrinks by one", current: 6, depth: 5, latency: time.Second, expected: 5},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, autoscaler.Desired(tc.current, tc.depth, tc.latency))
+		})
+	}
+}
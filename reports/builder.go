@@ -4,144 +4,258 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"encoding/csv"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
-	"strconv"
-	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/google/uuid"
 	"github.com/victor-devv/report-gen/config"
+	"github.com/victor-devv/report-gen/storage"
 	"github.com/victor-devv/report-gen/store"
 )
 
 type ReportBuilder struct {
-	config      *config.Config
-	logger      *slog.Logger
-	reportStore *store.ReportStore
-	lozClient   *LozClient
-	s3Client    *s3.Client
+	config          *config.Config
+	logger          *slog.Logger
+	reportStore     *store.ReportStore
+	dataSourceStore *store.DataSourceStore
+	compendium      Compendium
+	regions         RegionTable
+	httpClient      HttpClient
+	storage         storage.Storage
 }
 
-func NewReportBuilder(config *config.Config, logger *slog.Logger, reportStore *store.ReportStore, lozClient *LozClient, s3Client *s3.Client) *ReportBuilder {
+func NewReportBuilder(config *config.Config, logger *slog.Logger, reportStore *store.ReportStore, dataSourceStore *store.DataSourceStore, compendium Compendium, regions RegionTable, httpClient HttpClient, reportStorage storage.Storage) *ReportBuilder {
 	return &ReportBuilder{
 		config,
 		logger,
 		reportStore,
-		lozClient,
-		s3Client,
+		dataSourceStore,
+		compendium,
+		regions,
+		httpClient,
+		reportStorage,
 	}
 }
 
+// ReportDefinition ties a report type to its data source and data quality rules.
+type ReportDefinition struct {
+	Source     DataSource
+	Validation store.Validation
+	Assertions store.Assertions
+	// Timeout bounds a single build, zero falls back to DEFAULT_BUILD_TIMEOUT
+	Timeout time.Duration
+}
+
+// Definition resolves the definition of a report type: the built in monsters
+// report or an admin registered http json data source of the same name.
+func (b *ReportBuilder) Definition(ctx context.Context, reportType string, options store.ReportOptions) (*ReportDefinition, error) {
+	if reportType == MonstersReportType {
+		return &ReportDefinition{
+			Source: NewMonstersSource(b.compendium, b.regions, options.Enrich),
+			Validation: store.Validation{
+				Required: []string{"id", "name"},
+				Unique:   []string{"id"},
+				Urls:     []string{"image"},
+			},
+			Assertions: store.Assertions{MinRows: 1},
+			Timeout:    b.config.MonstersBuildTimeout,
+		}, nil
+	}
+
+	dataSource, err := b.dataSourceStore.ByName(ctx, reportType)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w %q", ErrUnknownReportType, reportType)
+		}
+		return nil, err
+	}
+
+	definition := &ReportDefinition{
+		Source:     NewHttpJsonSource(dataSource, b.httpClient),
+		Validation: dataSource.Validation.Val,
+		Assertions: dataSource.Assertions.Val,
+	}
+
+	if dataSource.TimeoutSeconds != nil {
+		definition.Timeout = time.Duration(*dataSource.TimeoutSeconds) * time.Second
+	}
+
+	return definition, nil
+}
+
+// BuildTimeout returns the timeout for a report type: BUILD_TIMEOUTS overrides
+// the definition, which overrides DEFAULT_BUILD_TIMEOUT. It never exceeds the claim
+// lease, past which another worker may claim the report.
+func (b *ReportBuilder) BuildTimeout(reportType string, definition *ReportDefinition) time.Duration {
+	timeout := b.config.DefaultBuildTimeout
+	if configured, ok := b.config.BuildTimeouts[reportType]; ok && configured > 0 {
+		timeout = configured
+	} else if definition.Timeout > 0 {
+		timeout = definition.Timeout
+	}
+
+	if lease := b.config.ClaimLease(); lease > 0 {
+		return min(timeout, lease)
+	}
+	return timeout
+}
+
 func (b *ReportBuilder) Build(ctx context.Context, userId, reportId uuid.UUID) (report *store.Report, err error) {
+	logger := LoggerFromContext(ctx, b.logger)
+
 	report, err = b.reportStore.ByPrimaryKey(ctx, reportId, userId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get report %s for user %s: %w", reportId, userId, err)
 	}
 
-	if report.StartedAt != nil {
+	if report.IsDone() {
 		return report, nil
 	}
 
+	// leave the report pending so the message is redelivered once upstream recovers
+	if report.ReportType == MonstersReportType && b.UpstreamState() == BreakerOpen {
+		return nil, fmt.Errorf("failed to build report %s: %w", reportId, ErrCircuitOpen)
+	}
+
+	// the named result is nil by the time a failing return reaches the defer,
+	// so keep hold of the claimed row separately
+	var claimed *store.Report
+	// files uploaded before a failure, e.g. the quarantine file, count towards the quota
+	var uploadedBytes int64
 	defer func() {
-		if err != nil {
+		if err != nil && claimed != nil {
 			now := time.Now()
-			errMsg := err.Error()
-			report.FailedAt = &now
-			report.ErrorMessage = &errMsg
-			if _, updateErr := b.reportStore.Update(ctx, report); updateErr != nil {
-				b.logger.Error("failed to update report", "error", err.Error())
+			claimed.OutputBytes = uploadedBytes
+			failure := err
+			// say why the build was cancelled, e.g. a timeout or failed heartbeat, not just "context canceled".
+			// The build context is cancelled by the time this runs, which alone says nothing.
+			if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) && !errors.Is(err, cause) {
+				failure = fmt.Errorf("%w: %w", err, cause)
+			}
+			errMsg := failure.Error()
+			errCode := ErrorCode(failure)
+			claimed.FailedAt = &now
+			claimed.ErrorMessage = &errMsg
+			claimed.ErrorCode = &errCode
+			// the build context may be the reason we failed, record the failure regardless
+			updateCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+			defer cancel()
+			if _, updateErr := b.reportStore.UpdateClaimed(updateCtx, claimed); updateErr != nil {
+				logger.Error("failed to update report", "error", updateErr.Error())
 			}
 		}
 	}()
 
-	now := time.Now()
-	report.StartedAt = &now
-	report.CompletedAt = nil
-	report.FailedAt = nil
-	report.ErrorMessage = nil
-	report.OutputFilePath = nil
-	report.DownloadUrl = nil
-	report.DownloadUrlExpiresAt = nil
-	report, err = b.reportStore.Update(ctx, report)
+	// duplicate deliveries race here, only one worker wins the claim and builds
+	report, err = b.reportStore.Claim(ctx, userId, reportId, b.config.WorkerId, b.config.ClaimLease())
 	if err != nil {
-		return nil, fmt.Errorf("failed to update report %s for user %s: %w", reportId, userId, err)
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Info("report already claimed by another worker", "report_id", reportId.String())
+			return b.reportStore.ByPrimaryKey(ctx, reportId, userId)
+		}
+		return nil, err
 	}
+	claimed = report
 
-	//we assume that report type will always be monsters for this project
-	resp, err := b.lozClient.GetMonsters()
+	definition, err := b.Definition(ctx, report.ReportType, report.Options.Val)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get monsters data: %w", err)
+		return nil, err
 	}
 
-	if len(resp.Data) == 0 {
-		return nil, fmt.Errorf("no monsters data found")
+	timeout := b.BuildTimeout(report.ReportType, definition)
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w after %s", ErrBuildTimeout, timeout))
+	defer cancel()
+
+	dataset, err := definition.Source.Fetch(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	var buffer bytes.Buffer
-	gzipWriter := gzip.NewWriter(&buffer)
+	if maxRows := b.config.MaxReportRows; maxRows > 0 && len(dataset.Rows) > maxRows {
+		return nil, fmt.Errorf("%w: got %d rows, limit is %d", ErrMaxRowsExceeded, len(dataset.Rows), maxRows)
+	}
 
-	csvWriter := csv.NewWriter(gzipWriter)
+	dataset, quarantined, err := Validate(dataset, definition.Validation)
+	if err != nil {
+		return nil, err
+	}
 
-	header := []string{"id", "name", "category", "description", "image", "common_locations", "drops", "dlc"}
-	if err := csvWriter.Write(header); err != nil {
-		return nil, fmt.Errorf("failed to write header to csv: %w", err)
+	if len(dataset.Rows) == 0 {
+		return nil, fmt.Errorf("%w: no valid %s rows, %d rows quarantined", ErrNoData, report.ReportType, len(quarantined.Rows))
 	}
 
-	for _, monster := range resp.Data {
-		row := []string{
-			fmt.Sprintf("%d", monster.Id),
-			monster.Name,
-			monster.Category,
-			monster.Description,
-			monster.Image,
-			strings.Join(monster.CommonLocations, ", "),
-			strings.Join(monster.Drops, ", "),
-			strconv.FormatBool(monster.Dlc),
-		}
+	if err := Assert(dataset, definition.Assertions); err != nil {
+		return nil, err
+	}
 
-		if err := csvWriter.Write(row); err != nil {
-			return nil, fmt.Errorf("failed to write row to csv: %w", err)
+	if len(quarantined.Rows) > 0 {
+		quarantineKey := storage.QuarantineKey(userId, reportId)
+		size, err := b.upload(ctx, quarantineKey, quarantined, QuarantineMetadata(report))
+		if err != nil {
+			return nil, err
 		}
+		uploadedBytes += size
 
-		if err := csvWriter.Error(); err != nil {
-			return nil, fmt.Errorf("failed to write row to csv: %w", err)
-		}
+		logger.Warn("report rows quarantined", "report_id", reportId.String(), "rows", len(quarantined.Rows), "path", quarantineKey)
+		report.QuarantineFilePath = &quarantineKey
+		report.QuarantinedRows = len(quarantined.Rows)
 	}
 
-	csvWriter.Flush()
-	if err := csvWriter.Error(); err != nil {
-		return nil, fmt.Errorf("failed to flush csv writer: %w", err)
+	key := storage.ReportKey(userId, reportId)
+	size, err := b.upload(ctx, key, dataset, OutputMetadata(report))
+	if err != nil {
+		return nil, err
 	}
+	uploadedBytes += size
+	report.OutputBytes = uploadedBytes
 
-	if err := gzipWriter.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close gzip writer: %w", err)
+	now := time.Now()
+	report.OutputFilePath = &key
+	report.CompletedAt = &now
+	report, err = b.reportStore.UpdateClaimed(ctx, report)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// another worker took over, leave the report to it
+			claimed = nil
+			return nil, fmt.Errorf("failed to complete report %s for user %s: %w", reportId, userId, ErrClaimLost)
+		}
+		return nil, fmt.Errorf("failed to update report %s for user %s: %w", reportId, userId, err)
 	}
 
-	// Upload the CSV file to S3
-	key := "/users/" + userId.String() + "/reports/" + reportId.String() + "csv.gz"
-	_, err = b.s3Client.PutObject(ctx, &s3.PutObjectInput{
-		Key:    aws.String(key),
-		Bucket: aws.String(b.config.S3Bucket),
-		Body:   bytes.NewReader(buffer.Bytes()),
-	})
+	logger.Info("report generated successfully", "report_id", reportId.String(), "user_id", userId.String(), "path", key)
+
+	return report, nil
+}
+
+// upload writes dataset to key as a gzipped csv and returns its size.
+func (b *ReportBuilder) upload(ctx context.Context, key string, dataset *Dataset, metadata storage.Metadata) (int64, error) {
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&limitWriter{w: &buffer, max: b.config.MaxReportOutputBytes})
+
+	if err := dataset.WriteCsv(gzipWriter); err != nil {
+		return 0, err
+	}
 
 	if err := gzipWriter.Close(); err != nil {
-		return nil, fmt.Errorf("failed to upload report to %s: %w", key, err)
+		return 0, fmt.Errorf("failed to close gzip writer: %w", err)
 	}
 
-	now = time.Now()
-	report.OutputFilePath = &key
-	report.CompletedAt = &now
-	report, err = b.reportStore.Update(ctx, report)
-	if err != nil {
-		return nil, fmt.Errorf("failed to update report %s for user %s: %w", reportId, userId, err)
+	size := int64(buffer.Len())
+	if err := b.storage.Put(ctx, key, &buffer, size, metadata); err != nil {
+		return 0, fmt.Errorf("failed to upload report to %s: %w", key, err)
 	}
 
-	b.logger.Info("report generated successfully", "report_id", reportId.String(), "user_id", userId.String(), "path", key)
+	return size, nil
+}
 
-	return report, nil
+// UpstreamState returns the circuit breaker state of the upstream data api.
+// Snapshot backed compendiums never trip and always report closed.
+func (b *ReportBuilder) UpstreamState() BreakerState {
+	if c, ok := b.compendium.(interface{ Breaker() *CircuitBreaker }); ok {
+		return c.Breaker().State()
+	}
+	return BreakerClosed
 }
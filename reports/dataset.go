@@ -0,0 +1,39 @@
+package reports
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+// Dataset is the tabular output of a data source, one row per record.
+type Dataset struct {
+	Columns []string
+	Rows    [][]string
+}
+
+type DataSource interface {
+	Fetch(ctx context.Context) (*Dataset, error)
+}
+
+func (d *Dataset) WriteCsv(w io.Writer) error {
+	csvWriter := csv.NewWriter(w)
+
+	if err := csvWriter.Write(d.Columns); err != nil {
+		return fmt.Errorf("failed to write header to csv: %w", err)
+	}
+
+	for _, row := range d.Rows {
+		if err := csvWriter.Write(row); err != nil {
+			return fmt.Errorf("failed to write row to csv: %w", err)
+		}
+	}
+
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return fmt.Errorf("failed to flush csv writer: %w", err)
+	}
+
+	return nil
+}
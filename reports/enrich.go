@@ -0,0 +1,89 @@
+package reports
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// RegionTable maps a compendium location name to the region it belongs to.
+type RegionTable map[string]string
+
+// LoadRegionTable reads a json object of location to region names from path.
+// An empty path yields an empty table.
+func LoadRegionTable(path string) (RegionTable, error) {
+	regions := RegionTable{}
+	if path == "" {
+		return regions, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read region table %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(b, &regions); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal region table %s: %w", path, err)
+	}
+
+	return regions, nil
+}
+
+// Regions returns the distinct regions of locations in first seen order.
+// Locations missing from the table are skipped.
+func (t RegionTable) Regions(locations []string) []string {
+	var regions []string
+	for _, location := range locations {
+		if region, ok := t[location]; ok && !slices.Contains(regions, region) {
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+var enrichedMonsterColumns = []string{"drop_effects", "drop_hearts_recovered", "cooking_drops", "regions"}
+
+// monsterEnrichment joins a monster's drops against the materials category and
+// its locations against the region table.
+type monsterEnrichment struct {
+	materials map[string]Material
+	regions   RegionTable
+}
+
+func newMonsterEnrichment(materials []Material, regions RegionTable) *monsterEnrichment {
+	byName := make(map[string]Material, len(materials))
+	for _, material := range materials {
+		byName[strings.ToLower(material.Name)] = material
+	}
+
+	return &monsterEnrichment{materials: byName, regions: regions}
+}
+
+func (e *monsterEnrichment) columns(monster Monster) []string {
+	var effects []string
+	var hearts float64
+	var cookingDrops int
+
+	for _, drop := range monster.Drops {
+		material, ok := e.materials[strings.ToLower(drop)]
+		if !ok {
+			continue
+		}
+
+		cookingDrops++
+		hearts += material.HeartsRecovered
+		if material.CookingEffect != "" && !slices.Contains(effects, material.CookingEffect) {
+			effects = append(effects, material.CookingEffect)
+		}
+	}
+
+	return []string{
+		strings.Join(effects, ", "),
+		strconv.FormatFloat(hearts, 'f', -1, 64),
+		strconv.Itoa(cookingDrops),
+		strings.Join(e.regions.Regions(monster.CommonLocations), ", "),
+	}
+}
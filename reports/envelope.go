@@ -0,0 +1,95 @@
+package reports
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/victor-devv/report-gen/store"
+)
+
+const (
+	// MessageVersion is the envelope version written by NewEnvelope.
+	// Version 1 messages are bare SqsMessage bodies without an envelope.
+	MessageVersion = 2
+
+	JobKindReportBuild = "report.build"
+)
+
+// ErrUnsupportedMessageVersion is returned for envelopes newer than this build understands.
+// They are not dead lettered right away so a worker that is already upgraded can pick them up.
+var ErrUnsupportedMessageVersion = errors.New("unsupported message version")
+
+// Envelope wraps every queue message with what the worker needs to route and trace it.
+type Envelope struct {
+	Version    int       `json:"version"`
+	Kind       string    `json:"kind"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	// RequestId is the id of the api request that caused the message, if any
+	RequestId string `json:"request_id,omitempty"`
+	// TraceParent is the w3c trace context of that request
+	TraceParent string          `json:"traceparent,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+// NewEnvelope encodes payload as a message body of the given kind.
+func NewEnvelope(kind, requestId, traceParent string, payload any) (string, error) {
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s payload: %w", kind, err)
+	}
+
+	bytes, err = json.Marshal(Envelope{
+		Version:     MessageVersion,
+		Kind:        kind,
+		EnqueuedAt:  time.Now().UTC(),
+		RequestId:   requestId,
+		TraceParent: traceParent,
+		Payload:     bytes,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s envelope: %w", kind, err)
+	}
+
+	return string(bytes), nil
+}
+
+// NewReportBuildMessage is the message that has report built by the worker.
+func NewReportBuildMessage(report *store.Report, requestId, traceParent string) (string, error) {
+	return NewEnvelope(JobKindReportBuild, requestId, traceParent, SqsMessage{
+		UserId:   report.UserId,
+		ReportId: report.Id,
+		Priority: report.Priority,
+	})
+}
+
+// DecodeEnvelope decodes any supported message version. Version 1 bodies, sent before
+// the envelope existed, are wrapped as report builds so old messages keep working.
+func DecodeEnvelope(body string) (*Envelope, error) {
+	if body == "" {
+		return nil, fmt.Errorf("%w: message body is empty", ErrInvalidMessage)
+	}
+
+	var envelope Envelope
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+		return nil, fmt.Errorf("%w: message body is not valid json: %v", ErrInvalidMessage, err)
+	}
+
+	switch {
+	case envelope.Version == 0:
+		return &Envelope{
+			Version: 1,
+			Kind:    JobKindReportBuild,
+			Payload: json.RawMessage(body),
+		}, nil
+	case envelope.Version > MessageVersion:
+		return nil, fmt.Errorf("%w %d, this worker understands up to %d", ErrUnsupportedMessageVersion, envelope.Version, MessageVersion)
+	}
+
+	if envelope.Kind == "" {
+		return nil, fmt.Errorf("%w: message has no kind", ErrInvalidMessage)
+	}
+
+	return &envelope, nil
+}
@@ -0,0 +1,62 @@
+package reports_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/reports"
+	"github.com/victor-devv/report-gen/store"
+)
+
+func TestDecodeEnvelope(t *testing.T) {
+	report := &store.Report{Id: uuid.New(), UserId: uuid.New(), Priority: 3}
+
+	t.Run("decodes the current version", func(t *testing.T) {
+		body, err := reports.NewReportBuildMessage(report, "req-1", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		require.NoError(t, err)
+
+		envelope, err := reports.DecodeEnvelope(body)
+		require.NoError(t, err)
+		require.Equal(t, reports.MessageVersion, envelope.Version)
+		require.Equal(t, reports.JobKindReportBuild, envelope.Kind)
+		require.Equal(t, "req-1", envelope.RequestId)
+		require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", envelope.TraceParent)
+		require.False(t, envelope.EnqueuedAt.IsZero())
+
+		var msg reports.SqsMessage
+		require.NoError(t, json.Unmarshal(envelope.Payload, &msg))
+		require.Equal(t, reports.SqsMessage{UserId: report.UserId, ReportId: report.Id, Priority: 3}, msg)
+	})
+
+	t.Run("wraps version 1 messages as report builds", func(t *testing.T) {
+		body := `{"user_id":"` + report.UserId.String() + `","report_id":"` + report.Id.String() + `"}`
+
+		envelope, err := reports.DecodeEnvelope(body)
+		require.NoError(t, err)
+		require.Equal(t, 1, envelope.Version)
+		require.Equal(t, reports.JobKindReportBuild, envelope.Kind)
+
+		var msg reports.SqsMessage
+		require.NoError(t, json.Unmarshal(envelope.Payload, &msg))
+		require.Equal(t, report.Id, msg.ReportId)
+	})
+
+	t.Run("leaves newer versions for upgraded workers", func(t *testing.T) {
+		_, err := reports.DecodeEnvelope(`{"version":99,"kind":"report.build","payload":{}}`)
+		require.ErrorIs(t, err, reports.ErrUnsupportedMessageVersion)
+		require.NotErrorIs(t, err, reports.ErrInvalidMessage)
+	})
+
+	t.Run("rejects invalid messages", func(t *testing.T) {
+		_, err := reports.DecodeEnvelope("")
+		require.ErrorIs(t, err, reports.ErrInvalidMessage)
+
+		_, err = reports.DecodeEnvelope("{not json")
+		require.ErrorIs(t, err, reports.ErrInvalidMessage)
+
+		_, err = reports.DecodeEnvelope(`{"version":2,"payload":{}}`)
+		require.ErrorIs(t, err, reports.ErrInvalidMessage)
+	})
+}
@@ -0,0 +1,71 @@
+package reports
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrUpstreamClient = errors.New("upstream client error")
+	ErrUpstreamServer = errors.New("upstream server error")
+	ErrUpstreamDecode = errors.New("upstream decode error")
+	ErrCircuitOpen    = errors.New("upstream circuit breaker is open")
+)
+
+// UpstreamError describes a failed call to an upstream api. Kind is one of
+// ErrUpstreamClient, ErrUpstreamServer or ErrUpstreamDecode so callers can use errors.Is.
+type UpstreamError struct {
+	Kind       error
+	StatusCode int
+	Url        string
+	Err        error
+}
+
+func (e *UpstreamError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s: %s returned status %d: %v", e.Kind, e.Url, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Kind, e.Url, e.Err)
+}
+
+func (e *UpstreamError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+func newStatusError(url string, statusCode int) *UpstreamError {
+	kind := ErrUpstreamClient
+	if statusCode >= http.StatusInternalServerError {
+		kind = ErrUpstreamServer
+	}
+
+	return &UpstreamError{
+		Kind:       kind,
+		StatusCode: statusCode,
+		Url:        url,
+		Err:        errors.New(http.StatusText(statusCode)),
+	}
+}
+
+// isTransient reports whether err is worth retrying: 5xx responses, rate limiting,
+// request timeouts and transport failures. Client and decode errors are permanent.
+func isTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		switch {
+		case errors.Is(upstreamErr.Kind, ErrUpstreamServer):
+			return true
+		case upstreamErr.StatusCode == http.StatusTooManyRequests, upstreamErr.StatusCode == http.StatusRequestTimeout:
+			return true
+		}
+		return false
+	}
+
+	// transport level failures (dns, connection reset, client timeout)
+	return true
+}
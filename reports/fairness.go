@@ -0,0 +1,128 @@
+package reports
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/victor-devv/report-gen/queue"
+)
+
+// ScheduledMessage is a received message with the fields the worker schedules it by.
+// UserId is zero for messages that can't be decoded, they are left for the consumer to reject.
+type ScheduledMessage struct {
+	queue.Message
+	UserId   uuid.UUID
+	Priority int
+}
+
+// FairOrder orders a batch of received messages by descending priority and, within
+// the same priority, round robin over users so one user's burst can't fill the batch
+// ahead of everybody else. Messages of the same user keep their received order.
+// current holds the current priority of reports by id and takes precedence over the
+// priority the message was sent with, so reports bumped after enqueueing move up.
+func FairOrder(messages []queue.Message, current map[uuid.UUID]int) []ScheduledMessage {
+	byPriority := map[int][][]ScheduledMessage{}
+	userIndexes := map[int]map[uuid.UUID]int{}
+
+	for _, message := range messages {
+		scheduled := ScheduledMessage{Message: message}
+		if msg, ok := decodeBuildMessage(message); ok {
+			scheduled.UserId = msg.UserId
+			scheduled.Priority = msg.Priority
+			if priority, ok := current[msg.ReportId]; ok {
+				scheduled.Priority = priority
+			}
+		}
+
+		indexes, ok := userIndexes[scheduled.Priority]
+		if !ok {
+			indexes = map[uuid.UUID]int{}
+			userIndexes[scheduled.Priority] = indexes
+		}
+
+		i, ok := indexes[scheduled.UserId]
+		if !ok {
+			i = len(byPriority[scheduled.Priority])
+			indexes[scheduled.UserId] = i
+			byPriority[scheduled.Priority] = append(byPriority[scheduled.Priority], nil)
+		}
+		byPriority[scheduled.Priority][i] = append(byPriority[scheduled.Priority][i], scheduled)
+	}
+
+	priorities := make([]int, 0, len(byPriority))
+	for priority := range byPriority {
+		priorities = append(priorities, priority)
+	}
+	slices.Sort(priorities)
+	slices.Reverse(priorities)
+
+	ordered := make([]ScheduledMessage, 0, len(messages))
+	for _, priority := range priorities {
+		users := byPriority[priority]
+		for round := 0; ; round++ {
+			taken := false
+			for _, userMessages := range users {
+				if round < len(userMessages) {
+					ordered = append(ordered, userMessages[round])
+					taken = true
+				}
+			}
+			if !taken {
+				break
+			}
+		}
+	}
+
+	return ordered
+}
+
+// decodeBuildMessage decodes the payload of a report build message, ok is false for
+// other kinds and messages that can't be decoded.
+func decodeBuildMessage(message queue.Message) (msg SqsMessage, ok bool) {
+	envelope, err := DecodeEnvelope(message.Body)
+	if err != nil || envelope.Kind != JobKindReportBuild {
+		return msg, false
+	}
+
+	if err := json.Unmarshal(envelope.Payload, &msg); err != nil {
+		return msg, false
+	}
+	return msg, true
+}
+
+// inFlightTracker counts the messages per user that are buffered or being processed.
+type inFlightTracker struct {
+	mu     sync.Mutex
+	counts map[uuid.UUID]int
+}
+
+// Acquire counts a message for user unless the user is already at limit. A limit of
+// zero disables the cap and force bypasses it, e.g. for prioritised reports.
+func (t *inFlightTracker) Acquire(userId uuid.UUID, limit int, force bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.counts == nil {
+		t.counts = map[uuid.UUID]int{}
+	}
+
+	if !force && limit > 0 && userId != uuid.Nil && t.counts[userId] >= limit {
+		return false
+	}
+
+	t.counts[userId]++
+	return true
+}
+
+func (t *inFlightTracker) Release(userId uuid.UUID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.counts[userId] <= 1 {
+		delete(t.counts, userId)
+		return
+	}
+	t.counts[userId]--
+}
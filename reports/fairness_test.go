@@ -0,0 +1,67 @@
+package reports_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/queue"
+	"github.com/victor-devv/report-gen/reports"
+	"github.com/victor-devv/report-gen/store"
+)
+
+func TestFairOrder(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+
+	message := func(id string, userId uuid.UUID, priority int) queue.Message {
+		body, err := reports.NewReportBuildMessage(&store.Report{Id: uuid.New(), UserId: userId, Priority: priority}, "", "")
+		require.NoError(t, err)
+		return queue.Message{Id: id, Body: body}
+	}
+
+	// messages enqueued before the envelope existed
+	legacyMessage := func(id string, userId uuid.UUID) queue.Message {
+		body, err := json.Marshal(reports.SqsMessage{UserId: userId, ReportId: uuid.New()})
+		require.NoError(t, err)
+		return queue.Message{Id: id, Body: string(body)}
+	}
+
+	ordered := reports.FairOrder([]queue.Message{
+		message("a1", alice, 0),
+		message("a2", alice, 0),
+		message("a3", alice, 0),
+		legacyMessage("b1", bob),
+		{Id: "invalid", Body: "not json"},
+		message("b2", bob, 5),
+	}, nil)
+
+	ids := make([]string, 0, len(ordered))
+	for _, scheduled := range ordered {
+		ids = append(ids, scheduled.Id)
+	}
+
+	require.Equal(t, []string{"b2", "a1", "b1", "invalid", "a2", "a3"}, ids)
+	require.Equal(t, bob, ordered[0].UserId)
+	require.Equal(t, 5, ordered[0].Priority)
+	require.Equal(t, uuid.Nil, ordered[3].UserId)
+}
+
+func TestFairOrderUsesCurrentPriorities(t *testing.T) {
+	userId := uuid.New()
+	pending := &store.Report{Id: uuid.New(), UserId: userId}
+	bumped := &store.Report{Id: uuid.New(), UserId: userId}
+
+	var messages []queue.Message
+	for _, report := range []*store.Report{pending, bumped} {
+		body, err := reports.NewReportBuildMessage(report, "", "")
+		require.NoError(t, err)
+		messages = append(messages, queue.Message{Id: report.Id.String(), Body: body})
+	}
+
+	// bumped was prioritised after its message was sent
+	ordered := reports.FairOrder(messages, map[uuid.UUID]int{bumped.Id: 10})
+	require.Equal(t, bumped.Id.String(), ordered[0].Id)
+	require.Equal(t, 10, ordered[0].Priority)
+	require.Equal(t, 0, ordered[1].Priority)
+}
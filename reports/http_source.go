@@ -0,0 +1,122 @@
+package reports
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/victor-devv/report-gen/store"
+)
+
+// HttpJsonSource fetches records from an admin registered json api and maps
+// them into report columns.
+type HttpJsonSource struct {
+	definition  *store.DataSource
+	httpClient  HttpClient
+	retryPolicy RetryPolicy
+}
+
+func NewHttpJsonSource(definition *store.DataSource, httpClient HttpClient) *HttpJsonSource {
+	return &HttpJsonSource{
+		definition:  definition,
+		httpClient:  httpClient,
+		retryPolicy: DefaultRetryPolicy,
+	}
+}
+
+func (s *HttpJsonSource) Fetch(ctx context.Context) (*Dataset, error) {
+	var doc any
+	if err := s.retryPolicy.Do(ctx, func(ctx context.Context) error {
+		return s.get(ctx, &doc)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to fetch data source %s: %w", s.definition.Name, err)
+	}
+
+	records, err := lookupPath(doc, s.definition.RecordsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find records in data source %s: %w", s.definition.Name, err)
+	}
+
+	items, ok := records.([]any)
+	if !ok {
+		return nil, fmt.Errorf("records path %q of data source %s does not point to an array", s.definition.RecordsPath, s.definition.Name)
+	}
+
+	mapping := s.definition.FieldMapping.Val
+	dataset := &Dataset{Columns: make([]string, 0, len(mapping))}
+	for _, field := range mapping {
+		dataset.Columns = append(dataset.Columns, field.Column)
+	}
+
+	for i, item := range items {
+		row := make([]string, 0, len(mapping))
+		for _, field := range mapping {
+			value, err := lookupPath(item, field.Path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to map record %d column %s: %w", i, field.Column, err)
+			}
+			row = append(row, formatValue(value))
+		}
+		dataset.Rows = append(dataset.Rows, row)
+	}
+
+	return dataset, nil
+}
+
+func (s *HttpJsonSource) requestUrl() (string, error) {
+	rawUrl := s.definition.BaseUrl
+	if s.definition.Path != "" {
+		rawUrl = strings.TrimSuffix(rawUrl, "/") + "/" + strings.TrimPrefix(s.definition.Path, "/")
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", fmt.Errorf("invalid data source url: %w", err)
+	}
+
+	queryParams := u.Query()
+	for k, v := range s.definition.QueryParams.Val {
+		queryParams.Set(k, v)
+	}
+	u.RawQuery = queryParams.Encode()
+
+	return u.String(), nil
+}
+
+func (s *HttpJsonSource) get(ctx context.Context, v any) error {
+	reqUrl, err := s.requestUrl()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqUrl, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	if s.definition.AuthHeaderName != nil && s.definition.AuthHeaderValue != nil {
+		req.Header.Set(*s.definition.AuthHeaderName, *s.definition.AuthHeaderValue)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to submit http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(io.Discard, resp.Body)
+		return newStatusError(reqUrl, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return &UpstreamError{Kind: ErrUpstreamDecode, StatusCode: resp.StatusCode, Url: reqUrl, Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package reports_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/reports"
+	"github.com/victor-devv/report-gen/store"
+)
+
+func TestHttpJsonSource(t *testing.T) {
+	httpClient := &fakeHttpClient{responses: []*http.Response{newResponse(http.StatusOK, `{
+		"result": {"items": [
+			{"id": 1, "name": "lynel", "tags": ["boss", "centaur"], "stats": {"hp": 2000.5}},
+			{"id": 2, "name": "keese", "tags": [], "stats": {"hp": 1}}
+		]}
+	}`)}}
+
+	source := reports.NewHttpJsonSource(&store.DataSource{
+		Name:        "creatures",
+		BaseUrl:     "https://example.com/api",
+		Path:        "/creatures",
+		QueryParams: store.NewJson(map[string]string{"game": "totk"}),
+		RecordsPath: "$.result.items",
+		FieldMapping: store.NewJson([]store.FieldMapping{
+			{Column: "id", Path: "id"},
+			{Column: "name", Path: "$.name"},
+			{Column: "tags", Path: "tags"},
+			{Column: "hp", Path: "stats.hp"},
+			{Column: "missing", Path: "stats.mp"},
+		}),
+	}, httpClient)
+
+	dataset, err := source.Fetch(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"id", "name", "tags", "hp", "missing"}, dataset.Columns)
+	require.Equal(t, [][]string{
+		{"1", "lynel", "boss, centaur", "2000.5", ""},
+		{"2", "keese", "", "1", ""},
+	}, dataset.Rows)
+}
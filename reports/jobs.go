@@ -0,0 +1,97 @@
+package reports
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/victor-devv/report-gen/store"
+)
+
+const (
+	JobKindCacheWarmup      = "cache.warmup"
+	JobKindRetentionCleanup = "retention.cleanup"
+)
+
+// BackgroundJobKinds can be enqueued on their own, report builds are enqueued by creating a report.
+var BackgroundJobKinds = []string{JobKindCacheWarmup, JobKindRetentionCleanup}
+
+var ErrJobTimeout = errors.New("job timed out")
+
+// JobHandler runs a single job. Returning an error leaves the message to be retried
+// according to the job's retry policy, wrap ErrInvalidMessage to dead letter it right away.
+type JobHandler func(ctx context.Context, envelope *Envelope) error
+
+// Job registers the handler of a job kind with the way the worker runs it.
+type Job struct {
+	Kind    string
+	Handler JobHandler
+	// Timeout cancels the handler, zero leaves it to MaxBuildRuntime
+	Timeout time.Duration
+	// Retry.MaxAttempts is the receive count at which a failing message is dead lettered,
+	// zero uses MaxReceiveCount. Failed messages are retried after Retry.Backoff, or once
+	// their visibility timeout expires when Retry.BaseDelay is zero.
+	Retry RetryPolicy
+}
+
+// NewCacheWarmupJob fetches the compendium ahead of builds, so the first builds after
+// a deploy don't all pay for the cold materials cache.
+func NewCacheWarmupJob(compendium Compendium) Job {
+	return Job{
+		Kind: JobKindCacheWarmup,
+		Handler: func(ctx context.Context, envelope *Envelope) error {
+			if _, err := compendium.GetMonsters(ctx); err != nil {
+				return fmt.Errorf("failed to warm up monsters: %w", err)
+			}
+			if _, err := compendium.GetMaterials(ctx); err != nil {
+				return fmt.Errorf("failed to warm up materials: %w", err)
+			}
+			LoggerFromContext(ctx, slog.Default()).Info("compendium cache warmed up")
+			return nil
+		},
+		Timeout: time.Minute,
+		Retry:   RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Second, MaxDelay: time.Minute},
+	}
+}
+
+// RetentionCleanupPayload overrides the configured retention of a cleanup run.
+type RetentionCleanupPayload struct {
+	OutboxRetention string `json:"outbox_retention,omitempty"`
+}
+
+// NewRetentionCleanupJob deletes outbox messages sent longer than retention ago.
+func NewRetentionCleanupJob(outboxStore *store.OutboxStore, retention time.Duration) Job {
+	return Job{
+		Kind: JobKindRetentionCleanup,
+		Handler: func(ctx context.Context, envelope *Envelope) error {
+			var payload RetentionCleanupPayload
+			if len(envelope.Payload) > 0 {
+				if err := json.Unmarshal(envelope.Payload, &payload); err != nil {
+					return fmt.Errorf("%w: %s payload is not valid: %v", ErrInvalidMessage, envelope.Kind, err)
+				}
+			}
+
+			outboxRetention := retention
+			if payload.OutboxRetention != "" {
+				d, err := time.ParseDuration(payload.OutboxRetention)
+				if err != nil {
+					return fmt.Errorf("%w: invalid outbox_retention: %v", ErrInvalidMessage, err)
+				}
+				outboxRetention = d
+			}
+
+			deleted, err := outboxStore.DeleteSentBefore(ctx, time.Now().Add(-outboxRetention))
+			if err != nil {
+				return err
+			}
+
+			LoggerFromContext(ctx, slog.Default()).Info("retention cleanup finished", "outbox_deleted", deleted, "outbox_retention", outboxRetention.String())
+			return nil
+		},
+		Timeout: 5 * time.Minute,
+		Retry:   RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: 10 * time.Minute},
+	}
+}
@@ -0,0 +1,99 @@
+package reports
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// lookupPath resolves a JSONPath-style pointer such as "$.data.items[0].name"
+// against a decoded json document. "$" or an empty path returns the document itself.
+func lookupPath(doc any, path string) (any, error) {
+	segments, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	current := doc
+	for _, segment := range segments {
+		switch node := current.(type) {
+		case map[string]any:
+			value, ok := node[segment]
+			if !ok {
+				return nil, nil
+			}
+			current = value
+		case []any:
+			index, err := strconv.Atoi(segment)
+			if err != nil {
+				return nil, fmt.Errorf("path %q: %q is not an array index", path, segment)
+			}
+			if index < 0 || index >= len(node) {
+				return nil, nil
+			}
+			current = node[index]
+		case nil:
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("path %q: cannot descend into %T at %q", path, current, segment)
+		}
+	}
+
+	return current, nil
+}
+
+func splitPath(path string) ([]string, error) {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$")
+	path = strings.TrimPrefix(path, ".")
+	if path == "" {
+		return nil, nil
+	}
+
+	var segments []string
+	for _, part := range strings.Split(path, ".") {
+		name, rest, _ := strings.Cut(part, "[")
+		if name != "" {
+			segments = append(segments, name)
+		}
+		for rest != "" {
+			index, tail, ok := strings.Cut(rest, "]")
+			if !ok || index == "" {
+				return nil, fmt.Errorf("path %q: unterminated index", path)
+			}
+			segments = append(segments, index)
+			rest = strings.TrimPrefix(tail, "[")
+		}
+	}
+
+	return segments, nil
+}
+
+// formatValue renders a json value as a csv cell. Arrays are joined with ", "
+// like the built in reports, objects are written as compact json.
+func formatValue(v any) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case bool:
+		return strconv.FormatBool(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case json.Number:
+		return value.String()
+	case []any:
+		parts := make([]string, 0, len(value))
+		for _, item := range value {
+			parts = append(parts, formatValue(item))
+		}
+		return strings.Join(parts, ", ")
+	default:
+		b, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Sprintf("%v", value)
+		}
+		return string(b)
+	}
+}
@@ -0,0 +1,132 @@
+package reports
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Error codes stored on failed reports so clients can tell failure kinds apart.
+const (
+	ErrorCodeBuildTimeout       = "build_timeout"
+	ErrorCodeMaxRowsExceeded    = "max_rows_exceeded"
+	ErrorCodeMaxOutputExceeded  = "max_output_bytes_exceeded"
+	ErrorCodeUpstreamTooLarge   = "upstream_response_too_large"
+	ErrorCodeUpstreamError      = "upstream_error"
+	ErrorCodeAssertionFailed    = "assertion_failed"
+	ErrorCodeNoData             = "no_data"
+	ErrorCodeBuildFailed        = "build_failed"
+	ErrorCodeUnknownReportType  = "unknown_report_type"
+	ErrorCodeHeartbeatFailed    = "heartbeat_failed"
+	ErrorCodeMaxRuntimeExceeded = "max_runtime_exceeded"
+	ErrorCodeStuck              = "stuck"
+)
+
+var (
+	ErrMaxRowsExceeded          = errors.New("report exceeds the maximum number of rows")
+	ErrMaxOutputBytesExceeded   = errors.New("report exceeds the maximum output size")
+	ErrUpstreamResponseTooLarge = errors.New("upstream response exceeds the maximum size")
+	ErrBuildTimeout             = errors.New("report build timed out")
+	ErrNoData                   = errors.New("no data found")
+	ErrUnknownReportType        = errors.New("unknown report type")
+	ErrClaimLost                = errors.New("report claim lost to another worker")
+)
+
+// ErrorCode classifies a build error into one of the ErrorCode constants.
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrBuildTimeout):
+		return ErrorCodeBuildTimeout
+	case errors.Is(err, ErrMaxRowsExceeded):
+		return ErrorCodeMaxRowsExceeded
+	case errors.Is(err, ErrMaxOutputBytesExceeded):
+		return ErrorCodeMaxOutputExceeded
+	case errors.Is(err, ErrUpstreamResponseTooLarge):
+		return ErrorCodeUpstreamTooLarge
+	case errors.Is(err, ErrHeartbeatFailed):
+		return ErrorCodeHeartbeatFailed
+	case errors.Is(err, ErrMaxRuntimeExceeded):
+		return ErrorCodeMaxRuntimeExceeded
+	case errors.Is(err, ErrAssertionFailed):
+		return ErrorCodeAssertionFailed
+	case errors.Is(err, ErrNoData):
+		return ErrorCodeNoData
+	case errors.Is(err, ErrUnknownReportType):
+		return ErrorCodeUnknownReportType
+	case errors.Is(err, ErrUpstreamClient), errors.Is(err, ErrUpstreamServer), errors.Is(err, ErrUpstreamDecode), errors.Is(err, ErrCircuitOpen):
+		return ErrorCodeUpstreamError
+	}
+	return ErrorCodeBuildFailed
+}
+
+// limitedHttpClient fails reading any response body larger than maxBytes with ErrUpstreamResponseTooLarge.
+type limitedHttpClient struct {
+	httpClient HttpClient
+	maxBytes   int64
+}
+
+// NewLimitedHttpClient wraps httpClient so upstream responses over maxBytes fail instead of
+// being buffered in memory. A maxBytes of zero or less disables the limit.
+func NewLimitedHttpClient(httpClient HttpClient, maxBytes int64) HttpClient {
+	if maxBytes <= 0 {
+		return httpClient
+	}
+	return &limitedHttpClient{httpClient: httpClient, maxBytes: maxBytes}
+}
+
+func (c *limitedHttpClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.ContentLength > c.maxBytes {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s declared %d bytes, limit is %d", ErrUpstreamResponseTooLarge, req.URL.Redacted(), resp.ContentLength, c.maxBytes)
+	}
+
+	resp.Body = &limitedReadCloser{ReadCloser: resp.Body, remaining: c.maxBytes, limit: c.maxBytes}
+	return resp, nil
+}
+
+type limitedReadCloser struct {
+	io.ReadCloser
+	remaining int64
+	limit     int64
+}
+
+func (r *limitedReadCloser) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, fmt.Errorf("%w: limit is %d bytes", ErrUpstreamResponseTooLarge, r.limit)
+	}
+
+	// read one byte past the limit to tell "exactly at the limit" from "over it"
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, fmt.Errorf("%w: limit is %d bytes", ErrUpstreamResponseTooLarge, r.limit)
+	}
+	return n, err
+}
+
+// limitWriter fails writes that would take the total written past max with ErrMaxOutputBytesExceeded.
+type limitWriter struct {
+	w       io.Writer
+	written int64
+	max     int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.max > 0 && l.written+int64(len(p)) > l.max {
+		return 0, fmt.Errorf("%w of %d bytes", ErrMaxOutputBytesExceeded, l.max)
+	}
+
+	n, err := l.w.Write(p)
+	l.written += int64(n)
+	return n, err
+}
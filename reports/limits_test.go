@@ -0,0 +1,43 @@
+package reports_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/reports"
+)
+
+func TestLimitedHttpClient(t *testing.T) {
+	body := `{"data":[{"id":1,"name":"` + strings.Repeat("a", 100) + `"}]}`
+
+	t.Run("rejects oversized responses", func(t *testing.T) {
+		httpClient := &fakeHttpClient{responses: []*http.Response{newResponse(http.StatusOK, body)}}
+		client := reports.NewLozClient(reports.NewLimitedHttpClient(httpClient, 64))
+
+		_, err := client.GetMonsters(context.Background())
+		require.ErrorIs(t, err, reports.ErrUpstreamResponseTooLarge)
+		require.Equal(t, reports.ErrorCodeUpstreamTooLarge, reports.ErrorCode(err))
+		require.Equal(t, 1, httpClient.calls)
+	})
+
+	t.Run("allows responses up to the limit", func(t *testing.T) {
+		httpClient := &fakeHttpClient{responses: []*http.Response{newResponse(http.StatusOK, body)}}
+		client := reports.NewLozClient(reports.NewLimitedHttpClient(httpClient, int64(len(body))))
+
+		resp, err := client.GetMonsters(context.Background())
+		require.NoError(t, err)
+		require.Len(t, resp.Data, 1)
+	})
+}
+
+func TestErrorCode(t *testing.T) {
+	require.Equal(t, reports.ErrorCodeBuildTimeout, reports.ErrorCode(fmt.Errorf("failed: %w: %w", context.DeadlineExceeded, reports.ErrBuildTimeout)))
+	require.Equal(t, reports.ErrorCodeMaxRowsExceeded, reports.ErrorCode(fmt.Errorf("%w: got 10", reports.ErrMaxRowsExceeded)))
+	require.Equal(t, reports.ErrorCodeUpstreamError, reports.ErrorCode(&reports.UpstreamError{Kind: reports.ErrUpstreamServer, Err: errors.New("bad gateway")}))
+	require.Equal(t, reports.ErrorCodeBuildFailed, reports.ErrorCode(errors.New("something else")))
+}
@@ -1,29 +1,50 @@
 package reports
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
+	"time"
 )
 
 const baseUrl = "https://botw-compendium.herokuapp.com/api/v3/compendium"
 
+// materials change far less often than reports are built, so they are shared across builds
+const materialsCacheTtl = time.Hour
+
 type HttpClient interface {
 	Do(*http.Request) (*http.Response, error)
 }
 
 type LozClient struct {
-	baseUrl    string
-	httpClient HttpClient
+	baseUrl     string
+	httpClient  HttpClient
+	retryPolicy RetryPolicy
+	breaker     *CircuitBreaker
+
+	materialsMu        sync.Mutex
+	materials          *GetMaterialsResponse
+	materialsExpiresAt time.Time
 }
 
 func NewLozClient(httpClient HttpClient) *LozClient {
 	return &LozClient{
-		baseUrl:    baseUrl,
-		httpClient: httpClient,
+		baseUrl:     baseUrl,
+		httpClient:  httpClient,
+		retryPolicy: DefaultRetryPolicy,
+		breaker:     NewCircuitBreaker(5, 30*time.Second),
 	}
 }
 
+// Breaker exposes the circuit breaker guarding the compendium api so callers
+// can check whether upstream is currently considered down.
+func (c *LozClient) Breaker() *CircuitBreaker {
+	return c.breaker
+}
+
 type Monster struct {
 	Id              int      `json:"id"`
 	Name            string   `json:"name"`
@@ -39,26 +60,99 @@ type GetMonstersResponse struct {
 	Data []Monster `json:"data"`
 }
 
-func (c *LozClient) GetMonsters() (*GetMonstersResponse, error) {
-	req, err := http.NewRequest("GET", c.baseUrl+"/category/monsters", nil)
+func (c *LozClient) GetMonsters(ctx context.Context) (*GetMonstersResponse, error) {
+	var response GetMonstersResponse
+	if err := c.getCategory(ctx, "monsters", &response); err != nil {
+		return nil, fmt.Errorf("failed to get monsters: %w", err)
+	}
+
+	return &response, nil
+}
+
+type Material struct {
+	Id              int      `json:"id"`
+	Name            string   `json:"name"`
+	Category        string   `json:"category"`
+	Description     string   `json:"description"`
+	Image           string   `json:"image"`
+	CommonLocations []string `json:"common_locations"`
+	HeartsRecovered float64  `json:"hearts_recovered"`
+	CookingEffect   string   `json:"cooking_effect"`
+	Dlc             bool     `json:"dlc"`
+}
+
+type GetMaterialsResponse struct {
+	Data []Material `json:"data"`
+}
+
+// GetMaterials returns the materials category, served from an in memory cache
+// for materialsCacheTtl after a successful fetch.
+func (c *LozClient) GetMaterials(ctx context.Context) (*GetMaterialsResponse, error) {
+	c.materialsMu.Lock()
+	defer c.materialsMu.Unlock()
+
+	if c.materials != nil && time.Now().Before(c.materialsExpiresAt) {
+		return c.materials, nil
+	}
+
+	var response GetMaterialsResponse
+	if err := c.getCategory(ctx, "materials", &response); err != nil {
+		return nil, fmt.Errorf("failed to get materials: %w", err)
+	}
+
+	c.materials = &response
+	c.materialsExpiresAt = time.Now().Add(materialsCacheTtl)
+
+	return c.materials, nil
+}
+
+// getCategory fetches a compendium category into v, retrying transient failures
+// and failing fast while the circuit breaker is open.
+func (c *LozClient) getCategory(ctx context.Context, category string, v any) error {
+	return c.retryPolicy.Do(ctx, func(ctx context.Context) error {
+		if err := c.breaker.Allow(); err != nil {
+			return err
+		}
+
+		err := c.doGet(ctx, c.baseUrl+"/category/"+category, v)
+		switch {
+		case ctx.Err() != nil:
+			// a cancelled request says nothing about upstream health
+			c.breaker.Abandon()
+		case isTransient(err):
+			c.breaker.Failure()
+		default:
+			c.breaker.Success()
+		}
+		return err
+	})
+}
+
+func (c *LozClient) doGet(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create monsters request: %w", err)
+		return fmt.Errorf("failed to create request: %w", err)
 	}
 
-	reqUrl := req.URL
 	queryParams := req.URL.Query()
 	queryParams.Set("game", "totk")
-	reqUrl.RawQuery = queryParams.Encode()
+	req.URL.RawQuery = queryParams.Encode()
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to submit monsters http request: %w", err)
+		return fmt.Errorf("failed to submit http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// drain so the connection can be reused
+		io.Copy(io.Discard, resp.Body)
+		return newStatusError(url, resp.StatusCode)
 	}
 
-	var response *GetMonstersResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal monsters http response :%w", err)
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return &UpstreamError{Kind: ErrUpstreamDecode, StatusCode: resp.StatusCode, Url: url, Err: err}
 	}
 
-	return response, nil
+	return nil
 }
@@ -0,0 +1,80 @@
+package reports_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/reports"
+)
+
+type fakeHttpClient struct {
+	responses []*http.Response
+	calls     int
+}
+
+func (c *fakeHttpClient) Do(req *http.Request) (*http.Response, error) {
+	resp := c.responses[min(c.calls, len(c.responses)-1)]
+	c.calls++
+	return resp, nil
+}
+
+func newResponse(status int, body string) *http.Response {
+	return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+func TestLozClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("retries server errors", func(t *testing.T) {
+		httpClient := &fakeHttpClient{responses: []*http.Response{
+			newResponse(http.StatusBadGateway, ""),
+			newResponse(http.StatusOK, `{"data":[{"id":1,"name":"bokoblin"}]}`),
+		}}
+		client := reports.NewLozClient(httpClient)
+
+		resp, err := client.GetMonsters(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 2, httpClient.calls)
+		require.Len(t, resp.Data, 1)
+		require.Equal(t, "bokoblin", resp.Data[0].Name)
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		httpClient := &fakeHttpClient{responses: []*http.Response{newResponse(http.StatusNotFound, "")}}
+		client := reports.NewLozClient(httpClient)
+
+		_, err := client.GetMonsters(ctx)
+		require.ErrorIs(t, err, reports.ErrUpstreamClient)
+		require.Equal(t, 1, httpClient.calls)
+
+		var upstreamErr *reports.UpstreamError
+		require.True(t, errors.As(err, &upstreamErr))
+		require.Equal(t, http.StatusNotFound, upstreamErr.StatusCode)
+	})
+
+	t.Run("classifies decode errors", func(t *testing.T) {
+		httpClient := &fakeHttpClient{responses: []*http.Response{newResponse(http.StatusOK, "<html>")}}
+		client := reports.NewLozClient(httpClient)
+
+		_, err := client.GetMonsters(ctx)
+		require.ErrorIs(t, err, reports.ErrUpstreamDecode)
+		require.Equal(t, 1, httpClient.calls)
+	})
+
+	t.Run("opens the circuit after repeated failures", func(t *testing.T) {
+		httpClient := &fakeHttpClient{responses: []*http.Response{newResponse(http.StatusServiceUnavailable, "")}}
+		client := reports.NewLozClient(httpClient)
+
+		_, err := client.GetMonsters(ctx)
+		require.ErrorIs(t, err, reports.ErrUpstreamServer)
+
+		_, err = client.GetMonsters(ctx)
+		require.ErrorIs(t, err, reports.ErrCircuitOpen)
+		require.Equal(t, reports.BreakerOpen, client.Breaker().State())
+	})
+}
@@ -0,0 +1,66 @@
+package reports
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const MonstersReportType = "monsters"
+
+// MonstersSource is the built in compendium monsters report. With enrich set,
+// drops are joined against the materials category and locations against regions.
+type MonstersSource struct {
+	compendium Compendium
+	regions    RegionTable
+	enrich     bool
+}
+
+func NewMonstersSource(compendium Compendium, regions RegionTable, enrich bool) *MonstersSource {
+	return &MonstersSource{compendium: compendium, regions: regions, enrich: enrich}
+}
+
+func (s *MonstersSource) Fetch(ctx context.Context) (*Dataset, error) {
+	resp, err := s.compendium.GetMonsters(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monsters data: %w", err)
+	}
+
+	dataset := &Dataset{
+		Columns: []string{"id", "name", "category", "description", "image", "common_locations", "drops", "dlc"},
+		Rows:    make([][]string, 0, len(resp.Data)),
+	}
+
+	var enrichment *monsterEnrichment
+	if s.enrich {
+		materials, err := s.compendium.GetMaterials(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get materials data for enrichment: %w", err)
+		}
+
+		enrichment = newMonsterEnrichment(materials.Data, s.regions)
+		dataset.Columns = append(dataset.Columns, enrichedMonsterColumns...)
+	}
+
+	for _, monster := range resp.Data {
+		row := []string{
+			fmt.Sprintf("%d", monster.Id),
+			monster.Name,
+			monster.Category,
+			monster.Description,
+			monster.Image,
+			strings.Join(monster.CommonLocations, ", "),
+			strings.Join(monster.Drops, ", "),
+			strconv.FormatBool(monster.Dlc),
+		}
+
+		if enrichment != nil {
+			row = append(row, enrichment.columns(monster)...)
+		}
+
+		dataset.Rows = append(dataset.Rows, row)
+	}
+
+	return dataset, nil
+}
@@ -0,0 +1,48 @@
+package reports_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/reports"
+)
+
+func TestMonstersSourceEnrichment(t *testing.T) {
+	compendium := reports.NewSnapshotCompendium(fstest.MapFS{
+		"monsters.json": {Data: []byte(`{"data":[
+			{"id":1,"name":"bokoblin","common_locations":["Hyrule Field","Great Hyrule Forest"],"drops":["Bokoblin Horn","Bokoblin Fang","Apple"]}
+		]}`)},
+		"materials.json": {Data: []byte(`{"data":[
+			{"id":10,"name":"Apple","hearts_recovered":0.5,"cooking_effect":""},
+			{"id":11,"name":"Bokoblin Horn","hearts_recovered":0,"cooking_effect":"elixir"}
+		]}`)},
+	})
+	regions := reports.RegionTable{"Hyrule Field": "Central Hyrule"}
+
+	plain, err := reports.NewMonstersSource(compendium, regions, false).Fetch(context.Background())
+	require.NoError(t, err)
+	require.Len(t, plain.Columns, 8)
+	require.Len(t, plain.Rows[0], 8)
+
+	enriched, err := reports.NewMonstersSource(compendium, regions, true).Fetch(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"drop_effects", "drop_hearts_recovered", "cooking_drops", "regions"}, enriched.Columns[8:])
+	require.Equal(t, []string{"elixir", "0.5", "2", "Central Hyrule"}, enriched.Rows[0][8:])
+}
+
+func TestLozClientCachesMaterials(t *testing.T) {
+	httpClient := &fakeHttpClient{responses: []*http.Response{
+		newResponse(http.StatusOK, `{"data":[{"id":10,"name":"Apple"}]}`),
+	}}
+	client := reports.NewLozClient(httpClient)
+
+	for range 2 {
+		resp, err := client.GetMaterials(context.Background())
+		require.NoError(t, err)
+		require.Len(t, resp.Data, 1)
+	}
+	require.Equal(t, 1, httpClient.calls)
+}
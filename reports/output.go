@@ -0,0 +1,56 @@
+package reports
+
+import (
+	"fmt"
+	"mime"
+	"regexp"
+
+	"github.com/victor-devv/report-gen/storage"
+	"github.com/victor-devv/report-gen/store"
+)
+
+// Report outputs are csv files compressed with gzip, downloaded as .csv.gz attachments.
+// The compression is not declared as the content encoding, clients would decompress
+// downloads and lose their length and range support.
+const OutputContentType = "application/gzip"
+
+var (
+	unsafeFilenameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+	// S3 tag values allow letters, numbers, spaces and + - = . _ : / @
+	unsafeTagChars = regexp.MustCompile(`[^\pL\pN\s+\-=._:/@]+`)
+)
+
+// OutputFilename is a readable name for the output of report, e.g. monsters-2025-01-31-1a2b3c4d.csv.gz
+func OutputFilename(report *store.Report) string {
+	reportType := unsafeFilenameChars.ReplaceAllString(report.ReportType, "_")
+	return fmt.Sprintf("%s-%s-%s.csv.gz", reportType, report.CreatedAt.Format("2006-01-02"), report.Id.String()[:8])
+}
+
+// QuarantineFilename is OutputFilename for the quarantined rows of report.
+func QuarantineFilename(report *store.Report) string {
+	reportType := unsafeFilenameChars.ReplaceAllString(report.ReportType, "_")
+	return fmt.Sprintf("%s-%s-%s.quarantine.csv.gz", reportType, report.CreatedAt.Format("2006-01-02"), report.Id.String()[:8])
+}
+
+// OutputMetadata is the metadata of the output file of report.
+func OutputMetadata(report *store.Report) storage.Metadata {
+	return outputMetadata(report, OutputFilename(report), "output")
+}
+
+// QuarantineMetadata is the metadata of the quarantine file of report.
+func QuarantineMetadata(report *store.Report) storage.Metadata {
+	return outputMetadata(report, QuarantineFilename(report), "quarantine")
+}
+
+func outputMetadata(report *store.Report, filename, kind string) storage.Metadata {
+	return storage.Metadata{
+		ContentType:        OutputContentType,
+		ContentDisposition: mime.FormatMediaType("attachment", map[string]string{"filename": filename}),
+		Tags: map[string]string{
+			"user_id":     report.UserId.String(),
+			"report_id":   report.Id.String(),
+			"report_type": unsafeTagChars.ReplaceAllString(report.ReportType, "_"),
+			"file_kind":   kind,
+		},
+	}
+}
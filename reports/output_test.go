@@ -0,0 +1,35 @@
+package reports_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/reports"
+	"github.com/victor-devv/report-gen/store"
+)
+
+func TestOutputMetadata(t *testing.T) {
+	report := &store.Report{
+		Id:         uuid.MustParse("1a2b3c4d-0000-4000-8000-000000000000"),
+		UserId:     uuid.MustParse("6f1d0a3e-8f0e-4b8a-9d57-0c1e6b1f4a01"),
+		ReportType: "weather (hourly)",
+		CreatedAt:  time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC),
+	}
+
+	metadata := reports.OutputMetadata(report)
+	require.Equal(t, "application/gzip", metadata.ContentType)
+	require.Empty(t, metadata.ContentEncoding)
+	require.Equal(t, `attachment; filename=weather_hourly_-2025-01-31-1a2b3c4d.csv.gz`, metadata.ContentDisposition)
+	require.Equal(t, map[string]string{
+		"user_id":     report.UserId.String(),
+		"report_id":   report.Id.String(),
+		"report_type": "weather _hourly_",
+		"file_kind":   "output",
+	}, metadata.Tags)
+
+	quarantine := reports.QuarantineMetadata(report)
+	require.Equal(t, `attachment; filename=weather_hourly_-2025-01-31-1a2b3c4d.quarantine.csv.gz`, quarantine.ContentDisposition)
+	require.Equal(t, "quarantine", quarantine.Tags["file_kind"])
+}
@@ -0,0 +1,110 @@
+package reports
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/victor-devv/report-gen/config"
+	"github.com/victor-devv/report-gen/store"
+)
+
+// how many stuck reports one reap handles, the rest wait for the next interval
+const reapBatchSize = 100
+
+// Reaper finds reports left in limbo, processing after their worker died or pending
+// after their message was lost, and enqueues them again. Reports that stay stuck
+// after ReaperMaxAttempts are marked failed instead.
+type Reaper struct {
+	config      *config.Config
+	logger      *slog.Logger
+	reportStore *store.ReportStore
+}
+
+func NewReaper(config *config.Config, logger *slog.Logger, reportStore *store.ReportStore) *Reaper {
+	return &Reaper{
+		config:      config,
+		logger:      logger,
+		reportStore: reportStore,
+	}
+}
+
+// Run reaps every ReaperInterval until ctx is done.
+func (r *Reaper) Run(ctx context.Context) {
+	r.logger.Info("starting reaper", "interval", r.config.ReaperInterval.String())
+
+	ticker := time.NewTicker(r.config.ReaperInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Info("stopping reaper")
+			return
+		case <-ticker.C:
+			if _, err := r.Reap(ctx); err != nil && ctx.Err() == nil {
+				r.logger.Error("failed to reap stuck reports", "error", err)
+			}
+		}
+	}
+}
+
+// Reap handles one batch of stuck reports and returns how many it requeued or failed.
+func (r *Reaper) Reap(ctx context.Context) (int, error) {
+	now := time.Now()
+	stuck, err := r.reportStore.Stuck(ctx, now.Add(-r.config.StuckProcessingAfter), now.Add(-r.config.StuckPendingAfter), reapBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	reaped := 0
+	for i := range stuck {
+		report := &stuck[i]
+		status := report.Status()
+
+		if report.Attempts >= r.config.ReaperMaxAttempts {
+			failed, err := r.fail(ctx, report, status)
+			if err != nil {
+				return reaped, err
+			}
+			if failed {
+				reaped++
+			}
+			continue
+		}
+
+		requeued, err := r.reportStore.Requeue(ctx, report, func(report *store.Report) (string, error) {
+			return NewReportBuildMessage(report, "", "")
+		})
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			return reaped, err
+		}
+
+		r.logger.Warn("requeued stuck report", "report_id", report.Id.String(), "status", status, "attempts", requeued.Attempts)
+		reaped++
+	}
+
+	return reaped, nil
+}
+
+// fail gives up on a stuck report, it reports false when the report changed since
+// it was read, e.g. a worker completed it in the meantime.
+func (r *Reaper) fail(ctx context.Context, report *store.Report, status string) (bool, error) {
+	errMsg := fmt.Sprintf("report was stuck %s and has been given up on after %d attempts", status, report.Attempts)
+
+	if _, err := r.reportStore.FailStuck(ctx, report, errMsg, ErrorCodeStuck); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	r.logger.Warn("failed stuck report", "report_id", report.Id.String(), "status", status, "attempts", report.Attempts)
+	return true, nil
+}
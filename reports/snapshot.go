@@ -0,0 +1,114 @@
+package reports
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const (
+	monstersSnapshotFile  = "monsters.json"
+	materialsSnapshotFile = "materials.json"
+)
+
+// Compendium provides hyrule compendium data, either live from the api or from a snapshot.
+type Compendium interface {
+	GetMonsters(ctx context.Context) (*GetMonstersResponse, error)
+	GetMaterials(ctx context.Context) (*GetMaterialsResponse, error)
+}
+
+// SnapshotCompendium serves compendium data from json files in fsys, one file per
+// category in the same shape as the api response. fsys can be an os.DirFS or an
+// embed.FS, so builds need no network access and are reproducible for a given snapshot.
+type SnapshotCompendium struct {
+	fsys fs.FS
+}
+
+func NewSnapshotCompendium(fsys fs.FS) *SnapshotCompendium {
+	return &SnapshotCompendium{fsys: fsys}
+}
+
+func (c *SnapshotCompendium) GetMonsters(ctx context.Context) (*GetMonstersResponse, error) {
+	var response GetMonstersResponse
+	if err := c.read(monstersSnapshotFile, &response); err != nil {
+		return nil, fmt.Errorf("failed to get monsters: %w", err)
+	}
+
+	return &response, nil
+}
+
+func (c *SnapshotCompendium) GetMaterials(ctx context.Context) (*GetMaterialsResponse, error) {
+	var response GetMaterialsResponse
+	if err := c.read(materialsSnapshotFile, &response); err != nil {
+		return nil, fmt.Errorf("failed to get materials: %w", err)
+	}
+
+	return &response, nil
+}
+
+func (c *SnapshotCompendium) read(name string, v any) error {
+	b, err := fs.ReadFile(c.fsys, name)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot %s: %w", name, err)
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("failed to unmarshal snapshot %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// WriteSnapshot fetches every category from source and writes them to dir.
+// Records are sorted by id so the same upstream data always yields the same files.
+func WriteSnapshot(ctx context.Context, source Compendium, dir string) error {
+	monsters, err := source.GetMonsters(ctx)
+	if err != nil {
+		return err
+	}
+
+	materials, err := source.GetMaterials(ctx)
+	if err != nil {
+		return err
+	}
+
+	slices.SortFunc(monsters.Data, func(a, b Monster) int {
+		return a.Id - b.Id
+	})
+	slices.SortFunc(materials.Data, func(a, b Material) int {
+		return a.Id - b.Id
+	})
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create snapshot dir %s: %w", dir, err)
+	}
+
+	if err := writeSnapshotFile(filepath.Join(dir, monstersSnapshotFile), monsters); err != nil {
+		return err
+	}
+
+	return writeSnapshotFile(filepath.Join(dir, materialsSnapshotFile), materials)
+}
+
+func writeSnapshotFile(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot %s: %w", path, err)
+	}
+
+	// write to a temp file first so a failed refresh never leaves a truncated snapshot behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write snapshot %s: %w", path, err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace snapshot %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package reports_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/reports"
+)
+
+func TestSnapshotCompendium(t *testing.T) {
+	ctx := context.Background()
+
+	source := reports.NewSnapshotCompendium(fstest.MapFS{
+		"monsters.json":  {Data: []byte(`{"data":[{"id":2,"name":"moblin"},{"id":1,"name":"bokoblin"}]}`)},
+		"materials.json": {Data: []byte(`{"data":[{"id":3,"name":"bokoblin horn","cooking_effect":""}]}`)},
+	})
+
+	dir := t.TempDir()
+	require.NoError(t, reports.WriteSnapshot(ctx, source, dir))
+
+	first, err := os.ReadFile(dir + "/monsters.json")
+	require.NoError(t, err)
+
+	snapshot := reports.NewSnapshotCompendium(os.DirFS(dir))
+	resp, err := snapshot.GetMonsters(ctx)
+	require.NoError(t, err)
+	require.Len(t, resp.Data, 2)
+	require.Equal(t, "bokoblin", resp.Data[0].Name)
+	require.Equal(t, "moblin", resp.Data[1].Name)
+
+	// refreshing from the same data must produce identical files
+	require.NoError(t, reports.WriteSnapshot(ctx, snapshot, dir))
+	second, err := os.ReadFile(dir + "/monsters.json")
+	require.NoError(t, err)
+	require.Equal(t, first, second)
+}
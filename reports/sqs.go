@@ -5,4 +5,6 @@ import "github.com/google/uuid"
 type SqsMessage struct {
 	UserId   uuid.UUID `json:"user_id"`
 	ReportId uuid.UUID `json:"report_id"`
+	// Priority is copied from the report, higher priorities are scheduled first
+	Priority int `json:"priority,omitempty"`
 }
@@ -0,0 +1,118 @@
+package reports
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/victor-devv/report-gen/store"
+)
+
+var ErrAssertionFailed = errors.New("report assertion failed")
+
+const quarantineReasonColumn = "validation_error"
+
+// Validate splits dataset into rows that pass the validation rules and a quarantine
+// dataset holding the failing rows with the reason appended as a last column.
+// For unique columns the first occurrence of a value is kept.
+func Validate(dataset *Dataset, rules store.Validation) (valid *Dataset, quarantined *Dataset, err error) {
+	required, err := columnIndexes(dataset, rules.Required)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unique, err := columnIndexes(dataset, rules.Unique)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	urls, err := columnIndexes(dataset, rules.Urls)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	valid = &Dataset{Columns: dataset.Columns}
+	quarantined = &Dataset{Columns: append(slices.Clone(dataset.Columns), quarantineReasonColumn)}
+
+	seen := make([]map[string]bool, len(unique))
+	for i := range seen {
+		seen[i] = map[string]bool{}
+	}
+
+	for _, row := range dataset.Rows {
+		var reasons []string
+
+		for _, i := range required {
+			if strings.TrimSpace(row[i]) == "" {
+				reasons = append(reasons, fmt.Sprintf("%s is required", dataset.Columns[i]))
+			}
+		}
+
+		for n, i := range unique {
+			if row[i] != "" && seen[n][row[i]] {
+				reasons = append(reasons, fmt.Sprintf("duplicate %s %q", dataset.Columns[i], row[i]))
+			}
+		}
+
+		for _, i := range urls {
+			if row[i] != "" && !isHttpUrl(row[i]) {
+				reasons = append(reasons, fmt.Sprintf("%s is not a valid url", dataset.Columns[i]))
+			}
+		}
+
+		if len(reasons) > 0 {
+			quarantined.Rows = append(quarantined.Rows, append(slices.Clone(row), strings.Join(reasons, "; ")))
+			continue
+		}
+
+		for n, i := range unique {
+			seen[n][row[i]] = true
+		}
+		valid.Rows = append(valid.Rows, row)
+	}
+
+	return valid, quarantined, nil
+}
+
+// Assert checks dataset against the report assertions, the returned error wraps ErrAssertionFailed.
+func Assert(dataset *Dataset, assertions store.Assertions) error {
+	if len(dataset.Rows) < assertions.MinRows {
+		return fmt.Errorf("%w: expected at least %d rows, got %d", ErrAssertionFailed, assertions.MinRows, len(dataset.Rows))
+	}
+
+	noDuplicates, err := columnIndexes(dataset, assertions.NoDuplicates)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range noDuplicates {
+		seen := map[string]bool{}
+		for _, row := range dataset.Rows {
+			if seen[row[i]] {
+				return fmt.Errorf("%w: duplicate %s %q", ErrAssertionFailed, dataset.Columns[i], row[i])
+			}
+			seen[row[i]] = true
+		}
+	}
+
+	return nil
+}
+
+func columnIndexes(dataset *Dataset, columns []string) ([]int, error) {
+	indexes := make([]int, 0, len(columns))
+	for _, column := range columns {
+		i := slices.Index(dataset.Columns, column)
+		if i < 0 {
+			return nil, fmt.Errorf("unknown column %q in report definition", column)
+		}
+		indexes = append(indexes, i)
+	}
+	return indexes, nil
+}
+
+func isHttpUrl(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,49 @@
+package reports_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/reports"
+	"github.com/victor-devv/report-gen/store"
+)
+
+func TestValidate(t *testing.T) {
+	dataset := &reports.Dataset{
+		Columns: []string{"id", "name", "image"},
+		Rows: [][]string{
+			{"1", "bokoblin", "https://example.com/1.png"},
+			{"1", "moblin", "https://example.com/2.png"},
+			{"2", "", "https://example.com/3.png"},
+			{"3", "lizalfos", "not a url"},
+			{"4", "keese", ""},
+		},
+	}
+
+	valid, quarantined, err := reports.Validate(dataset, store.Validation{
+		Required: []string{"id", "name"},
+		Unique:   []string{"id"},
+		Urls:     []string{"image"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{dataset.Rows[0], dataset.Rows[4]}, valid.Rows)
+	require.Equal(t, []string{"id", "name", "image", "validation_error"}, quarantined.Columns)
+	require.Len(t, quarantined.Rows, 3)
+	require.Equal(t, `duplicate id "1"`, quarantined.Rows[0][3])
+	require.Equal(t, "name is required", quarantined.Rows[1][3])
+	require.Equal(t, "image is not a valid url", quarantined.Rows[2][3])
+
+	_, _, err = reports.Validate(dataset, store.Validation{Required: []string{"missing"}})
+	require.Error(t, err)
+}
+
+func TestAssert(t *testing.T) {
+	dataset := &reports.Dataset{
+		Columns: []string{"id", "name"},
+		Rows:    [][]string{{"1", "bokoblin"}, {"2", "bokoblin"}},
+	}
+
+	require.NoError(t, reports.Assert(dataset, store.Assertions{MinRows: 2}))
+	require.ErrorIs(t, reports.Assert(dataset, store.Assertions{MinRows: 3}), reports.ErrAssertionFailed)
+	require.ErrorIs(t, reports.Assert(dataset, store.Assertions{NoDuplicates: []string{"name"}}), reports.ErrAssertionFailed)
+}
@@ -3,117 +3,589 @@ package reports
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/sqs"
-	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/google/uuid"
 	"github.com/victor-devv/report-gen/config"
+	"github.com/victor-devv/report-gen/queue"
+	"github.com/victor-devv/report-gen/store"
+)
+
+// receiveBackoff spaces out receive attempts while the queue keeps failing
+var receiveBackoff = RetryPolicy{
+	BaseDelay: time.Second,
+	MaxDelay:  30 * time.Second,
+}
+
+// emptyReceiveDelay stops the producer from spinning when long polling is disabled
+const emptyReceiveDelay = time.Second
+
+// releaseTimeout bounds returning a message to the queue once the worker is stopping
+const releaseTimeout = 5 * time.Second
+
+var (
+	// ErrInvalidMessage marks messages that can never be processed, they are dead lettered on first receipt.
+	ErrInvalidMessage     = errors.New("invalid message")
+	ErrHeartbeatFailed    = errors.New("failed to extend message visibility")
+	ErrMaxRuntimeExceeded = errors.New("maximum build runtime exceeded")
+	ErrDrainTimeout       = errors.New("worker drain timeout exceeded")
+	ErrUnknownJobKind     = errors.New("unknown job kind")
 )
 
 type Worker struct {
-	config      *config.Config
-	logger      *slog.Logger
-	builder     *ReportBuilder
-	sqsClient   *sqs.Client
-	channel     chan types.Message
-	concurrency int
+	config          *config.Config
+	logger          *slog.Logger
+	builder         *ReportBuilder
+	queue           queue.Queue
+	deadLetterStore *store.DeadLetterStore
+	channel         chan ScheduledMessage
+	concurrency     int
+	inFlight        inFlightTracker
+	jobs            map[string]Job
+	// nil unless WORKER_ADAPTIVE_CONCURRENCY is set
+	autoscaler *Autoscaler
+	latency    latencyTracker
+
+	poolMu sync.Mutex
+	// one stop channel per running consumer, closing it scales the pool down
+	consumers      []chan struct{}
+	nextConsumerId int
+	consumersWg    sync.WaitGroup
 }
 
-func NewWorker(config *config.Config, logger *slog.Logger, builder *ReportBuilder, sqsClient *sqs.Client, maxConcurrency int) *Worker {
-	return &Worker{
-		config:      config,
-		logger:      logger,
-		builder:     builder,
-		sqsClient:   sqsClient,
-		channel:     make(chan types.Message, maxConcurrency),
-		concurrency: maxConcurrency,
+// NewWorker builds a worker running concurrency consumers. With adaptive concurrency
+// enabled the pool starts at concurrency and is resized within the configured bounds.
+// Callers check the settings with Config.ValidateWorker first.
+func NewWorker(config *config.Config, logger *slog.Logger, builder *ReportBuilder, messageQueue queue.Queue, deadLetterStore *store.DeadLetterStore, concurrency int) *Worker {
+	var autoscaler *Autoscaler
+	if config.AdaptiveConcurrency {
+		autoscaler = &Autoscaler{
+			Min:      config.WorkerMinConcurrency,
+			Max:      config.WorkerMaxConcurrency,
+			Interval: config.WorkerScaleInterval,
+		}
+		concurrency = min(max(concurrency, autoscaler.Min), autoscaler.Max)
 	}
+
+	w := &Worker{
+		config:          config,
+		logger:          logger,
+		builder:         builder,
+		queue:           messageQueue,
+		deadLetterStore: deadLetterStore,
+		channel:         make(chan ScheduledMessage, concurrency),
+		concurrency:     concurrency,
+		jobs:            map[string]Job{},
+		autoscaler:      autoscaler,
+	}
+
+	// the build timeout depends on the report type and is applied by the builder
+	w.Register(Job{
+		Kind:    JobKindReportBuild,
+		Handler: w.buildReport,
+	})
+
+	return w
+}
+
+// Register adds the handler for a job kind, replacing any handler registered before.
+// It must be called before Start.
+func (w *Worker) Register(job Job) {
+	w.jobs[job.Kind] = job
 }
 
+// Start receives and processes messages until ctx is done. It then stops receiving,
+// releases buffered messages back to the queue and waits up to WorkerDrainTimeout
+// for in-flight builds before cancelling them.
 func (w *Worker) Start(ctx context.Context) error {
-	queueUrlOutput, err := w.sqsClient.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
-		QueueName: aws.String(w.config.SqsQueue),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to get url for queue %s: %w", w.config.SqsQueue, err)
-	}
+	w.logger.Info("starting worker", "queue_backend", w.config.QueueBackend)
 
-	w.logger.Info("starting worker", "queue", w.config.SqsQueue, "queue_url", queueUrlOutput.QueueUrl)
+	// builds don't inherit the shutdown signal, they are only cancelled when draining takes too long
+	buildCtx, cancelBuilds := context.WithCancelCause(context.WithoutCancel(ctx))
+	defer cancelBuilds(nil)
 
 	// SET UP CONSUMERS
-	// create go routines that matches max concurrency
-	for i := 0; i < w.concurrency; i++ {
-		go func(id int) {
-			w.logger.Info(fmt.Sprintf("starting worker #%d", id))
-			for {
-				select {
-				case <-ctx.Done():
-					w.logger.Info("stopping worker", "goroutine_id", id, "error", ctx.Err())
-				case message := <-w.channel:
-					if err := w.processMessage(ctx, message); err != nil {
-						w.logger.Error("failed to process message", "error", err, "goroutine_id", id)
-						continue
-					}
-
-					// remove message from queue
-					if _, err := w.sqsClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{
-						QueueUrl:      queueUrlOutput.QueueUrl,
-						ReceiptHandle: message.ReceiptHandle,
-					}); err != nil {
-						w.logger.Error("failed to delete message %s: %w", *message.MessageId, err)
-					}
-				}
+	w.resize(ctx, buildCtx, w.concurrency)
+
+	autoscaleDone := make(chan struct{})
+	go func() {
+		defer close(autoscaleDone)
+		if w.autoscaler != nil {
+			w.autoscale(ctx, buildCtx)
+		}
+	}()
+
+	// SETUP PRODUCER
+	w.produce(ctx)
+	// the pool must not grow while it is being drained
+	<-autoscaleDone
+
+	w.logger.Info("stopping worker, draining in-flight builds", "drain_timeout", w.config.WorkerDrainTimeout.String())
+	w.releaseBuffered(ctx)
+
+	drained := make(chan struct{})
+	go func() {
+		w.consumersWg.Wait()
+		close(drained)
+	}()
+
+	timer := time.NewTimer(w.config.WorkerDrainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-drained:
+	case <-timer.C:
+		w.logger.Warn("drain timeout exceeded, cancelling in-flight builds")
+		cancelBuilds(ErrDrainTimeout)
+		<-drained
+	}
+
+	w.logger.Info("worker stopped")
+	return nil
+}
+
+// resize starts or stops consumers until size are running. Stopped consumers finish
+// the message they are processing first.
+func (w *Worker) resize(ctx context.Context, buildCtx context.Context, size int) {
+	w.poolMu.Lock()
+	defer w.poolMu.Unlock()
+
+	for len(w.consumers) < size {
+		stop := make(chan struct{})
+		w.consumers = append(w.consumers, stop)
+		id := w.nextConsumerId
+		w.nextConsumerId++
+
+		w.consumersWg.Add(1)
+		go func() {
+			defer w.consumersWg.Done()
+			w.consume(ctx, buildCtx, stop, id)
+		}()
+	}
+
+	for len(w.consumers) > size {
+		last := len(w.consumers) - 1
+		close(w.consumers[last])
+		w.consumers = w.consumers[:last]
+	}
+
+	workerConcurrency.Set(int64(len(w.consumers)))
+}
+
+func (w *Worker) size() int {
+	w.poolMu.Lock()
+	defer w.poolMu.Unlock()
+	return len(w.consumers)
+}
+
+// autoscale resizes the pool every WorkerScaleInterval from the queue depth and the
+// average build latency until ctx is done.
+func (w *Worker) autoscale(ctx context.Context, buildCtx context.Context) {
+	depthReporter, ok := w.queue.(queue.DepthReporter)
+	if !ok {
+		w.logger.Warn("queue backend does not report its depth, adaptive concurrency disabled", "queue_backend", w.config.QueueBackend)
+		return
+	}
+
+	if w.autoscaler.Interval <= 0 {
+		w.logger.Warn("worker scale interval must be positive, adaptive concurrency disabled")
+		return
+	}
+
+	ticker := time.NewTicker(w.autoscaler.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		depth, err := depthReporter.Depth(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
 			}
-		}(i)
+			w.logger.Error("failed to get queue depth", "error", err)
+			continue
+		}
+		workerQueueDepth.Set(int64(depth))
+
+		current := w.size()
+		latency := w.latency.Average()
+		desired := w.autoscaler.Desired(current, depth, latency)
+		if desired == current {
+			continue
+		}
+
+		if desired > current {
+			workerScaleUps.Add(1)
+		} else {
+			workerScaleDowns.Add(1)
+		}
+
+		w.logger.Info("scaling worker pool", "from", current, "to", desired, "queue_depth", depth, "build_latency", latency.String())
+		w.resize(ctx, buildCtx, desired)
 	}
+}
 
-	// SETUP PRODUCER
+// consume processes messages from the channel until ctx is done or stop is closed.
+// Messages run on buildCtx so a build that already started is not interrupted by the shutdown.
+func (w *Worker) consume(ctx context.Context, buildCtx context.Context, stop <-chan struct{}, id int) {
+	w.logger.Info(fmt.Sprintf("starting worker #%d", id))
 	for {
-		output, err := w.sqsClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
-			QueueUrl:            queueUrlOutput.QueueUrl,
-			MaxNumberOfMessages: int32(w.concurrency + 1),
-		})
+		select {
+		case <-ctx.Done():
+			w.logger.Info("stopping worker", "goroutine_id", id)
+			return
+		case <-stop:
+			w.logger.Info("stopping worker, pool scaled down", "goroutine_id", id)
+			return
+		case message := <-w.channel:
+			// select picks at random when both cases are ready, don't start new builds once stopping
+			if ctx.Err() != nil {
+				w.inFlight.Release(message.UserId)
+				w.release(ctx, message.Message)
+				w.logger.Info("stopping worker", "goroutine_id", id)
+				return
+			}
+			w.handleMessage(buildCtx, id, message.Message)
+			w.inFlight.Release(message.UserId)
+		}
+	}
+}
+
+func (w *Worker) handleMessage(ctx context.Context, id int, message queue.Message) {
+	start := time.Now()
+	logger := w.logger.With("message_id", message.Id)
+
+	var job Job
+	envelope, err := DecodeEnvelope(message.Body)
+	if err == nil {
+		logger = logger.With("request_id", envelope.RequestId, "traceparent", envelope.TraceParent, "job_kind", envelope.Kind, "message_version", envelope.Version)
+		job, err = w.job(envelope.Kind)
+		if err == nil {
+			err = w.runJob(ContextWithLogger(ctx, logger), job, message, envelope)
+		}
+	}
+	w.latency.Observe(time.Since(start))
+
+	if err != nil {
+		logger.Error("failed to process message", "error", err, "goroutine_id", id, "receive_count", message.ReceiveCount)
+		if ctx.Err() != nil {
+			// cancelled by the drain timeout, not the message's fault
+			w.release(ctx, message)
+			return
+		}
+
+		if !w.shouldDeadLetter(message, job, err) {
+			w.retry(ctx, logger, job, message)
+			return
+		}
+
+		if err := w.deadLetter(ctx, message, err); err != nil {
+			logger.Error("failed to dead letter message", "error", err)
+			return
+		}
+	}
+
+	// remove message from queue
+	if err := w.queue.Ack(ctx, message); err != nil {
+		logger.Error("failed to delete message", "error", err)
+	}
+}
+
+func (w *Worker) job(kind string) (Job, error) {
+	job, ok := w.jobs[kind]
+	if !ok {
+		return Job{}, fmt.Errorf("%w: %w %q", ErrInvalidMessage, ErrUnknownJobKind, kind)
+	}
+	return job, nil
+}
+
+// retry schedules the next attempt of a failed message according to the job's retry policy.
+func (w *Worker) retry(ctx context.Context, logger *slog.Logger, job Job, message queue.Message) {
+	if job.Retry.BaseDelay <= 0 {
+		// left on the queue, it is redelivered once the visibility timeout expires
+		return
+	}
+
+	delay := job.Retry.Backoff(message.ReceiveCount)
+	if err := w.queue.Nack(ctx, message, delay); err != nil {
+		logger.Error("failed to schedule retry", "error", err)
+		return
+	}
+	logger.Info("scheduled retry", "retry_in", delay.String(), "receive_count", message.ReceiveCount)
+}
+
+// produce receives messages into the channel until ctx is done. Messages that could
+// not be handed to a consumer before then are released back to the queue.
+func (w *Worker) produce(ctx context.Context) {
+	failures := 0
+	for {
+		// don't pull more work while upstream is down, the builds would only fail fast
+		if state := w.builder.UpstreamState(); state == BreakerOpen {
+			w.logger.Warn("upstream circuit breaker is open, pausing receive", "breaker_state", state.String())
+			if !sleep(ctx, time.Second) {
+				return
+			}
+			continue
+		}
+
+		messages, err := w.queue.Receive(ctx, w.size()+1, w.config.ReceiveWaitTime)
 		if err != nil {
-			w.logger.Error("failed to receive message", "error", err)
 			if ctx.Err() != nil {
-				return ctx.Err()
+				return
+			}
+
+			failures++
+			delay := receiveBackoff.Backoff(failures)
+			w.logger.Error("failed to receive messages", "error", err, "consecutive_failures", failures, "retry_in", delay.String())
+			if !sleep(ctx, delay) {
+				return
 			}
+			continue
 		}
+		failures = 0
 
-		if len(output.Messages) == 0 {
+		if len(messages) == 0 {
+			if w.config.ReceiveWaitTime <= 0 && !sleep(ctx, emptyReceiveDelay) {
+				return
+			}
 			continue
 		}
 
-		for _, message := range output.Messages {
-			w.channel <- message
+		scheduled := FairOrder(messages, w.reportPriorities(ctx, messages))
+		for i, message := range scheduled {
+			// prioritised reports skip the per user cap
+			if !w.inFlight.Acquire(message.UserId, w.config.MaxInFlightPerUser, message.Priority > 0) {
+				w.deferMessage(ctx, message)
+				continue
+			}
+
+			select {
+			case w.channel <- message:
+			case <-ctx.Done():
+				w.inFlight.Release(message.UserId)
+				for _, message := range scheduled[i:] {
+					w.release(ctx, message.Message)
+				}
+				return
+			}
 		}
 	}
 }
 
-func (w *Worker) processMessage(ctx context.Context, message types.Message) error {
-	w.logger.Info("processing message", "message_id", *message.MessageId, "body", *message.Body)
-	if message.Body == nil || *message.Body == "" {
-		w.logger.Warn("message body is empty", "message_id", *message.MessageId)
+// reportPriorities loads the current priority of the reports the build messages in
+// messages are for, admins bump pending reports in the database without enqueueing
+// them again. On failure the priorities the messages were sent with are used.
+func (w *Worker) reportPriorities(ctx context.Context, messages []queue.Message) map[uuid.UUID]int {
+	if w.builder == nil || w.builder.reportStore == nil {
 		return nil
 	}
 
-	var msg SqsMessage
-	if err := json.Unmarshal([]byte(*message.Body), &msg); err != nil {
-		w.logger.Warn("message body is invalid", "message_id", *message.MessageId, "body", *message.Body)
+	ids := make([]uuid.UUID, 0, len(messages))
+	for _, message := range messages {
+		if msg, ok := decodeBuildMessage(message); ok {
+			ids = append(ids, msg.ReportId)
+		}
+	}
+
+	if len(ids) == 0 {
 		return nil
 	}
 
-	// Pass to report builder
-	// Handle max processing time for the report (10 secs)
-	builderCtx, builderCancel := context.WithTimeout(ctx, time.Second*10)
-	defer builderCancel()
-	_, err := w.builder.Build(builderCtx, msg.UserId, msg.ReportId)
+	priorities, err := w.builder.reportStore.Priorities(ctx, ids)
 	if err != nil {
+		w.logger.Error("failed to load report priorities, using the priorities messages were sent with", "error", err)
+		return nil
+	}
+	return priorities
+}
+
+// releaseBuffered returns messages still waiting in the channel to the queue.
+func (w *Worker) releaseBuffered(ctx context.Context) {
+	for {
+		select {
+		case message := <-w.channel:
+			w.inFlight.Release(message.UserId)
+			w.release(ctx, message.Message)
+		default:
+			return
+		}
+	}
+}
+
+// release makes message visible to other receivers right away instead of after its
+// visibility timeout. It runs detached from ctx as it is called while shutting down.
+func (w *Worker) release(ctx context.Context, message queue.Message) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+	defer cancel()
+
+	if err := w.queue.Nack(ctx, message, 0); err != nil {
+		w.logger.Error("failed to release message", "message_id", message.Id, "error", err)
+		return
+	}
+	w.logger.Info("released message back to queue", "message_id", message.Id)
+}
+
+// deferMessage hides message for FairShareDelay because its user already has
+// MaxInFlightPerUser messages in the worker, leaving the capacity to other users.
+// Deferrals are not attempts, they don't count towards retries or dead lettering.
+func (w *Worker) deferMessage(ctx context.Context, message ScheduledMessage) {
+	if err := w.queue.Defer(ctx, message.Message, w.config.FairShareDelay); err != nil {
+		w.logger.Error("failed to defer message", "message_id", message.Id, "error", err)
+		return
+	}
+	w.logger.Info("deferred message, user is at the in-flight limit", "message_id", message.Id, "user_id", message.UserId, "delay", w.config.FairShareDelay.String())
+}
+
+// sleep waits for d and reports false if ctx was done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// runJob runs the job's handler on envelope, keeping message hidden with heartbeats
+// while it runs and cancelling it once the job's timeout expires.
+func (w *Worker) runJob(ctx context.Context, job Job, message queue.Message, envelope *Envelope) error {
+	LoggerFromContext(ctx, w.logger).Info("processing message", "body", message.Body, "enqueued_at", envelope.EnqueuedAt)
+
+	heartbeatCtx, heartbeatCancel := context.WithCancelCause(ctx)
+	defer heartbeatCancel(nil)
+	jobCtx, jobCancel := context.WithCancel(heartbeatCtx)
+	defer jobCancel()
+
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		w.heartbeat(jobCtx, heartbeatCancel, message)
+	}()
+
+	handlerCtx := jobCtx
+	if job.Timeout > 0 {
+		var cancel context.CancelFunc
+		handlerCtx, cancel = context.WithTimeoutCause(jobCtx, job.Timeout, fmt.Errorf("%w after %s", ErrJobTimeout, job.Timeout))
+		defer cancel()
+	}
+
+	err := job.Handler(handlerCtx, envelope)
+	jobCancel()
+	<-heartbeatDone
+	if err != nil {
+		// a failed heartbeat or the max runtime, else the job's own timeout. Both contexts
+		// are cancelled by now, which alone says nothing.
+		cause := context.Cause(heartbeatCtx)
+		if cause == nil || cause == context.Canceled {
+			cause = context.Cause(handlerCtx)
+		}
+		if cause != nil && cause != context.Canceled {
+			return fmt.Errorf("failed to run %s job: %w: %w", job.Kind, cause, err)
+		}
+		return fmt.Errorf("failed to run %s job: %w", job.Kind, err)
+	}
+
+	return nil
+}
+
+// buildReport is the handler of report build jobs.
+func (w *Worker) buildReport(ctx context.Context, envelope *Envelope) error {
+	var msg SqsMessage
+	if err := json.Unmarshal(envelope.Payload, &msg); err != nil {
+		return fmt.Errorf("%w: %s payload is not valid: %v", ErrInvalidMessage, envelope.Kind, err)
+	}
+
+	if _, err := w.builder.Build(ctx, msg.UserId, msg.ReportId); err != nil {
 		return fmt.Errorf("failed to build report: %w", err)
 	}
 
 	return nil
 }
+
+// heartbeat keeps message hidden from other workers while its job runs by extending
+// the visibility timeout every HeartbeatInterval. The job is cancelled through cancel
+// when an extension fails, as the message may already be redelivered, or when it runs
+// longer than MaxBuildRuntime. It returns when ctx is done.
+func (w *Worker) heartbeat(ctx context.Context, cancel context.CancelCauseFunc, message queue.Message) {
+	logger := LoggerFromContext(ctx, w.logger)
+
+	// a zero interval or runtime disables that check, receiving from a nil channel blocks forever
+	var tick, maxRuntime <-chan time.Time
+	if w.config.HeartbeatInterval > 0 {
+		ticker := time.NewTicker(w.config.HeartbeatInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	if w.config.MaxBuildRuntime > 0 {
+		timer := time.NewTimer(w.config.MaxBuildRuntime)
+		defer timer.Stop()
+		maxRuntime = timer.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-maxRuntime:
+			logger.Warn("build exceeded max runtime, cancelling", "max_runtime", w.config.MaxBuildRuntime.String())
+			cancel(fmt.Errorf("%w after %s", ErrMaxRuntimeExceeded, w.config.MaxBuildRuntime))
+			return
+		case <-tick:
+			if err := w.queue.ExtendVisibility(ctx, message, w.config.QueueVisibilityTimeout); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				logger.Error("heartbeat failed, cancelling build", "error", err)
+				cancel(fmt.Errorf("%w: %w", ErrHeartbeatFailed, err))
+				return
+			}
+		}
+	}
+}
+
+type loggerCtxKey struct{}
+
+// ContextWithLogger attaches a logger carrying the attributes of the message being
+// processed, such as its request id, so every log line of the build includes them.
+func ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerCtxKey{}, logger)
+}
+
+func LoggerFromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if logger, ok := ctx.Value(loggerCtxKey{}).(*slog.Logger); ok && logger != nil {
+		return logger
+	}
+	return fallback
+}
+
+func (w *Worker) shouldDeadLetter(message queue.Message, job Job, err error) bool {
+	maxAttempts := w.config.MaxReceiveCount
+	if job.Retry.MaxAttempts > 0 {
+		maxAttempts = job.Retry.MaxAttempts
+	}
+	return errors.Is(err, ErrInvalidMessage) || message.ReceiveCount >= maxAttempts
+}
+
+// deadLetter records the message with its last error and removes it from the queue.
+func (w *Worker) deadLetter(ctx context.Context, message queue.Message, lastErr error) error {
+	deadLetter, err := w.deadLetterStore.Create(ctx, message.Id, message.Body, message.ReceiveCount, lastErr.Error())
+	if err != nil {
+		return err
+	}
+
+	w.logger.Warn("message moved to dead letters", "message_id", message.Id, "dead_letter_id", deadLetter.Id, "receive_count", message.ReceiveCount)
+	return nil
+}
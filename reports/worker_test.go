@@ -0,0 +1,262 @@
+package reports_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/config"
+	"github.com/victor-devv/report-gen/queue"
+	"github.com/victor-devv/report-gen/reports"
+)
+
+func TestWorkerShutdownReleasesUndeliveredMessages(t *testing.T) {
+	conf := &config.Config{
+		QueueBackend:       queue.BackendMemory,
+		ReceiveWaitTime:    50 * time.Millisecond,
+		WorkerDrainTimeout: time.Second,
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	builder := reports.NewReportBuilder(conf, logger, nil, nil, reports.NewSnapshotCompendium(fstest.MapFS{}), nil, nil, nil)
+
+	messageQueue := queue.NewMemoryQueue(time.Hour)
+	require.NoError(t, messageQueue.Send(context.Background(), `{"user_id":"u","report_id":"r"}`))
+
+	received := make(chan struct{}, 1)
+	// without consumers the received message can never be handed over
+	worker := reports.NewWorker(conf, logger, builder, &notifyingQueue{messageQueue, received}, nil, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- worker.Start(ctx)
+	}()
+
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("worker did not receive the message")
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("worker did not stop")
+	}
+
+	messages, err := messageQueue.Receive(context.Background(), 1, 0)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, 2, messages[0].ReceiveCount)
+}
+
+// notifyingQueue signals received whenever a receive returns messages.
+type notifyingQueue struct {
+	*queue.MemoryQueue
+	received chan struct{}
+}
+
+func (q *notifyingQueue) Receive(ctx context.Context, maxMessages int, wait time.Duration) ([]queue.Message, error) {
+	messages, err := q.MemoryQueue.Receive(ctx, maxMessages, wait)
+	if len(messages) > 0 {
+		select {
+		case q.received <- struct{}{}:
+		default:
+		}
+	}
+	return messages, err
+}
+
+func TestWorkerRunsRegisteredJobs(t *testing.T) {
+	conf := &config.Config{
+		QueueBackend:       queue.BackendMemory,
+		ReceiveWaitTime:    50 * time.Millisecond,
+		WorkerDrainTimeout: time.Second,
+		MaxReceiveCount:    5,
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	builder := reports.NewReportBuilder(conf, logger, nil, nil, reports.NewSnapshotCompendium(fstest.MapFS{}), nil, nil, nil)
+	messageQueue := queue.NewMemoryQueue(time.Hour)
+
+	worker := reports.NewWorker(conf, logger, builder, messageQueue, nil, 1)
+
+	// fails once, the retry policy redelivers it long before the visibility timeout
+	attempts := make(chan string, 2)
+	var calls atomic.Int32
+	worker.Register(reports.Job{
+		Kind: "test.job",
+		Handler: func(ctx context.Context, envelope *reports.Envelope) error {
+			attempts <- envelope.RequestId
+			if calls.Add(1) == 1 {
+				return errors.New("first attempt fails")
+			}
+			return nil
+		},
+		Retry: reports.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	})
+
+	body, err := reports.NewEnvelope("test.job", "req-1", "", map[string]string{})
+	require.NoError(t, err)
+	require.NoError(t, messageQueue.Send(context.Background(), body))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- worker.Start(ctx)
+	}()
+
+	for range 2 {
+		select {
+		case requestId := <-attempts:
+			require.Equal(t, "req-1", requestId)
+		case <-time.After(2 * time.Second):
+			t.Fatal("job was not retried")
+		}
+	}
+
+	cancel()
+	require.NoError(t, <-done)
+	require.Equal(t, int32(2), calls.Load())
+}
+
+func TestWorkerReportsJobTimeouts(t *testing.T) {
+	conf := &config.Config{
+		QueueBackend:       queue.BackendMemory,
+		ReceiveWaitTime:    50 * time.Millisecond,
+		WorkerDrainTimeout: time.Second,
+		MaxReceiveCount:    5,
+	}
+	var logs syncBuffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	builder := reports.NewReportBuilder(conf, logger, nil, nil, reports.NewSnapshotCompendium(fstest.MapFS{}), nil, nil, nil)
+	messageQueue := queue.NewMemoryQueue(time.Hour)
+
+	worker := reports.NewWorker(conf, logger, builder, messageQueue, nil, 1)
+
+	timedOut := make(chan struct{})
+	worker.Register(reports.Job{
+		Kind: "test.job",
+		Handler: func(ctx context.Context, envelope *reports.Envelope) error {
+			<-ctx.Done()
+			close(timedOut)
+			return ctx.Err()
+		},
+		Timeout: 10 * time.Millisecond,
+	})
+
+	body, err := reports.NewEnvelope("test.job", "", "", map[string]string{})
+	require.NoError(t, err)
+	require.NoError(t, messageQueue.Send(context.Background(), body))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- worker.Start(ctx)
+	}()
+
+	select {
+	case <-timedOut:
+	case <-time.After(2 * time.Second):
+		t.Fatal("job did not time out")
+	}
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), "failed to process message")
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	require.Contains(t, logs.String(), reports.ErrJobTimeout.Error())
+}
+
+func TestWorkerHeartbeatExtendsVisibility(t *testing.T) {
+	conf := &config.Config{
+		QueueBackend:           queue.BackendMemory,
+		ReceiveWaitTime:        50 * time.Millisecond,
+		WorkerDrainTimeout:     time.Second,
+		MaxReceiveCount:        5,
+		QueueVisibilityTimeout: 100 * time.Millisecond,
+		HeartbeatInterval:      20 * time.Millisecond,
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	builder := reports.NewReportBuilder(conf, logger, nil, nil, reports.NewSnapshotCompendium(fstest.MapFS{}), nil, nil, nil)
+	messageQueue := &extendCountingQueue{MemoryQueue: queue.NewMemoryQueue(conf.QueueVisibilityTimeout)}
+
+	worker := reports.NewWorker(conf, logger, builder, messageQueue, nil, 2)
+
+	// runs for several visibility timeouts, without heartbeats a second consumer would get it too
+	var calls atomic.Int32
+	done := make(chan struct{})
+	worker.Register(reports.Job{
+		Kind: "test.job",
+		Handler: func(ctx context.Context, envelope *reports.Envelope) error {
+			if calls.Add(1) == 1 {
+				defer close(done)
+			}
+			time.Sleep(300 * time.Millisecond)
+			return nil
+		},
+	})
+
+	body, err := reports.NewEnvelope("test.job", "", "", map[string]string{})
+	require.NoError(t, err)
+	require.NoError(t, messageQueue.Send(context.Background(), body))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- worker.Start(ctx)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("job did not run")
+	}
+	cancel()
+	require.NoError(t, <-stopped)
+
+	require.Equal(t, int32(1), calls.Load())
+	require.GreaterOrEqual(t, messageQueue.extended.Load(), int32(3))
+}
+
+// extendCountingQueue counts visibility extensions.
+type extendCountingQueue struct {
+	*queue.MemoryQueue
+	extended atomic.Int32
+}
+
+func (q *extendCountingQueue) ExtendVisibility(ctx context.Context, message queue.Message, timeout time.Duration) error {
+	q.extended.Add(1)
+	return q.MemoryQueue.ExtendVisibility(ctx, message, timeout)
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent loggers.
+type syncBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.String()
+}
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/victor-devv/report-gen/reports"
+	"github.com/victor-devv/report-gen/store"
+)
+
+type CreateDataSourceRequest struct {
+	Name            string               `json:"name"`
+	BaseUrl         string               `json:"base_url"`
+	Path            string               `json:"path"`
+	QueryParams     map[string]string    `json:"query_params"`
+	AuthHeaderName  *string              `json:"auth_header_name"`
+	AuthHeaderValue *string              `json:"auth_header_value"`
+	RecordsPath     string               `json:"records_path"`
+	FieldMapping    []store.FieldMapping `json:"field_mapping"`
+	Validation      store.Validation     `json:"validation"`
+	Assertions      store.Assertions     `json:"assertions"`
+	TimeoutSeconds  *int                 `json:"timeout_seconds"`
+}
+
+func (r CreateDataSourceRequest) Validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+
+	if r.Name == reports.MonstersReportType {
+		return fmt.Errorf("name %q is reserved for a built in report", r.Name)
+	}
+
+	baseUrl, err := url.Parse(r.BaseUrl)
+	if err != nil || (baseUrl.Scheme != "http" && baseUrl.Scheme != "https") || baseUrl.Host == "" {
+		return errors.New("base_url must be an absolute http(s) url")
+	}
+
+	if (r.AuthHeaderName == nil) != (r.AuthHeaderValue == nil) {
+		return errors.New("auth_header_name and auth_header_value must be set together")
+	}
+
+	if len(r.FieldMapping) == 0 {
+		return errors.New("field_mapping is required")
+	}
+
+	columns := make([]string, 0, len(r.FieldMapping))
+	for i, field := range r.FieldMapping {
+		if field.Column == "" || field.Path == "" {
+			return fmt.Errorf("field_mapping[%d] requires a column and a path", i)
+		}
+		columns = append(columns, field.Column)
+	}
+
+	ruleColumns := slices.Concat(r.Validation.Required, r.Validation.Unique, r.Validation.Urls, r.Assertions.NoDuplicates)
+	for _, column := range ruleColumns {
+		if !slices.Contains(columns, column) {
+			return fmt.Errorf("column %s used in validation or assertions is not in field_mapping", column)
+		}
+	}
+
+	if r.TimeoutSeconds != nil && *r.TimeoutSeconds <= 0 {
+		return errors.New("timeout_seconds must be positive")
+	}
+
+	if r.Assertions.MinRows < 0 {
+		return errors.New("assertions.min_rows must not be negative")
+	}
+
+	return nil
+}
+
+func (s *Server) createDataSourceHandler() http.HandlerFunc {
+	return handleWithError(func(w http.ResponseWriter, r *http.Request) error {
+		req, err := decode[CreateDataSourceRequest](r)
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusBadRequest)
+		}
+
+		existing, err := s.store.DataSources.ByName(r.Context(), req.Name)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+
+		if existing != nil {
+			return NewErrWithStatus(fmt.Errorf("a data source named %s already exists", req.Name), http.StatusConflict)
+		}
+
+		recordsPath := req.RecordsPath
+		if recordsPath == "" {
+			recordsPath = "$"
+		}
+
+		queryParams := req.QueryParams
+		if queryParams == nil {
+			queryParams = map[string]string{}
+		}
+
+		dataSource, err := s.store.DataSources.Create(r.Context(), &store.DataSource{
+			Name:            req.Name,
+			BaseUrl:         req.BaseUrl,
+			Path:            req.Path,
+			QueryParams:     store.NewJson(queryParams),
+			AuthHeaderName:  req.AuthHeaderName,
+			AuthHeaderValue: req.AuthHeaderValue,
+			RecordsPath:     recordsPath,
+			FieldMapping:    store.NewJson(req.FieldMapping),
+			Validation:      store.NewJson(req.Validation),
+			Assertions:      store.NewJson(req.Assertions),
+			TimeoutSeconds:  req.TimeoutSeconds,
+		})
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+
+		successResponse(w, http.StatusCreated, "data source created successfully", dataSource)
+		return nil
+	})
+}
+
+func (s *Server) listDataSourcesHandler() http.HandlerFunc {
+	return handleWithError(func(w http.ResponseWriter, r *http.Request) error {
+		dataSources, err := s.store.DataSources.List(r.Context())
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+
+		successResponse(w, http.StatusOK, "", dataSources)
+		return nil
+	})
+}
+
+func (s *Server) deleteDataSourceHandler() http.HandlerFunc {
+	return handleWithError(func(w http.ResponseWriter, r *http.Request) error {
+		id, err := uuid.Parse(r.PathValue("dataSource"))
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusBadRequest)
+		}
+
+		result, err := s.store.DataSources.Delete(r.Context(), id)
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+
+		if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+			return NewErrWithStatus(fmt.Errorf("data source %s not found", id), http.StatusNotFound)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+}
+
+func (s *Server) listDeadLettersHandler() http.HandlerFunc {
+	return handleWithError(func(w http.ResponseWriter, r *http.Request) error {
+		deadLetters, err := s.store.DeadLetters.List(r.Context())
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+
+		successResponse(w, http.StatusOK, "", deadLetters)
+		return nil
+	})
+}
+
+// redriveDeadLetterHandler puts a dead lettered message back on the queue and removes the dead letter.
+func (s *Server) redriveDeadLetterHandler() http.HandlerFunc {
+	return handleWithError(func(w http.ResponseWriter, r *http.Request) error {
+		id, err := uuid.Parse(r.PathValue("deadLetter"))
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusBadRequest)
+		}
+
+		deadLetter, err := s.store.DeadLetters.ById(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return NewErrWithStatus(err, http.StatusNotFound)
+			}
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+
+		if err := s.queue.Send(r.Context(), deadLetter.Body); err != nil {
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+
+		if _, err := s.store.DeadLetters.Delete(r.Context(), id); err != nil {
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+
+		successResponse(w, http.StatusOK, "dead letter redriven successfully", deadLetter)
+		return nil
+	})
+}
+
+func (s *Server) deleteDeadLetterHandler() http.HandlerFunc {
+	return handleWithError(func(w http.ResponseWriter, r *http.Request) error {
+		id, err := uuid.Parse(r.PathValue("deadLetter"))
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusBadRequest)
+		}
+
+		result, err := s.store.DeadLetters.Delete(r.Context(), id)
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+
+		if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+			return NewErrWithStatus(fmt.Errorf("dead letter %s not found", id), http.StatusNotFound)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+}
+
+type PurgeDeadLettersResponse struct {
+	Purged int64 `json:"purged"`
+}
+
+func (s *Server) purgeDeadLettersHandler() http.HandlerFunc {
+	return handleWithError(func(w http.ResponseWriter, r *http.Request) error {
+		result, err := s.store.DeadLetters.Purge(r.Context())
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+
+		purged, err := result.RowsAffected()
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+
+		successResponse(w, http.StatusOK, "", PurgeDeadLettersResponse{Purged: purged})
+		return nil
+	})
+}
+
+type SetReportPriorityRequest struct {
+	Priority int `json:"priority"`
+}
+
+func (r SetReportPriorityRequest) Validate() error {
+	if r.Priority < 0 {
+		return errors.New("priority must not be negative")
+	}
+	return nil
+}
+
+// setReportPriorityHandler bumps a pending report. Only the row changes, workers look up
+// the current priority of the reports they receive and schedule the report accordingly.
+func (s *Server) setReportPriorityHandler() http.HandlerFunc {
+	return handleWithError(func(w http.ResponseWriter, r *http.Request) error {
+		id, err := uuid.Parse(r.PathValue("report"))
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusBadRequest)
+		}
+
+		req, err := decode[SetReportPriorityRequest](r)
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusBadRequest)
+		}
+
+		report, err := s.store.Reports.SetPriority(r.Context(), id, req.Priority)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return NewErrWithStatus(fmt.Errorf("no pending report %s", id), http.StatusNotFound)
+			}
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+
+		successResponse(w, http.StatusOK, "report priority updated successfully", report)
+		return nil
+	})
+}
+
+type EnqueueJobRequest struct {
+	Kind    string          `json:"kind"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func (r EnqueueJobRequest) Validate() error {
+	if !slices.Contains(reports.BackgroundJobKinds, r.Kind) {
+		return fmt.Errorf("kind must be one of %v", reports.BackgroundJobKinds)
+	}
+	return nil
+}
+
+// enqueueJobHandler sends a background job, e.g. a cache warmup, to the worker.
+func (s *Server) enqueueJobHandler() http.HandlerFunc {
+	return handleWithError(func(w http.ResponseWriter, r *http.Request) error {
+		req, err := decode[EnqueueJobRequest](r)
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusBadRequest)
+		}
+
+		requestId, _ := GetReqIdFromContext(r.Context())
+		body, err := reports.NewEnvelope(req.Kind, requestId, r.Header.Get("traceparent"), req.Payload)
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+
+		if err := s.queue.Send(r.Context(), body); err != nil {
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+
+		successResponse(w, http.StatusAccepted, "job enqueued successfully", req)
+		return nil
+	})
+}
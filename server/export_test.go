@@ -0,0 +1,16 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// exported for the tests in server_test
+var (
+	ReportFilter = reportFilter
+	EncodeCursor = encodeCursor
+)
+
+func (s *Server) PresignTtl(r *http.Request) (time.Duration, error) {
+	return s.presignTtl(r)
+}
@@ -1,18 +1,24 @@
 package server
 
 import (
+	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/google/uuid"
 	"github.com/victor-devv/report-gen/reports"
+	"github.com/victor-devv/report-gen/storage"
 	"github.com/victor-devv/report-gen/store"
 )
 
@@ -202,28 +208,75 @@ func (s *Server) refreshTokenHandler() http.HandlerFunc {
 }
 
 type CreateReportRequest struct {
-	ReportType string `json:"report_type"`
+	ReportType string              `json:"report_type"`
+	Options    store.ReportOptions `json:"options"`
+	Labels     []string            `json:"labels"`
 }
 
 type CreateReportResponse struct {
-	Id                   uuid.UUID  `json:"id"`
-	ReportType           string     `json:"report_type,omitempty"`
-	OutputFilePath       *string    `json:"output_file_path,omitempty"`
-	DownloadUrl          *string    `json:"download_url,omitempty"`
-	DownloadUrlExpiresAt *time.Time `json:"download_url_expires_at,omitempty"`
-	ErrorMessage         *string    `json:"error_message,omitempty"`
-	CreatedAt            time.Time  `json:"created_at,omitempty"`
-	StartedAt            *time.Time `json:"started_at,omitempty"`
-	FailedAt             *time.Time `json:"failed_at,omitempty"`
-	CompletedAt          *time.Time `json:"completed_at,omitempty"`
-	Status               string     `json:"status,omitempty"`
+	Id                   uuid.UUID           `json:"id"`
+	ReportType           string              `json:"report_type,omitempty"`
+	OutputFilePath       *string             `json:"output_file_path,omitempty"`
+	DownloadUrl          *string             `json:"download_url,omitempty"`
+	DownloadUrlExpiresAt *time.Time          `json:"download_url_expires_at,omitempty"`
+	ErrorMessage         *string             `json:"error_message,omitempty"`
+	CreatedAt            time.Time           `json:"created_at,omitempty"`
+	StartedAt            *time.Time          `json:"started_at,omitempty"`
+	FailedAt             *time.Time          `json:"failed_at,omitempty"`
+	CompletedAt          *time.Time          `json:"completed_at,omitempty"`
+	Status               string              `json:"status,omitempty"`
+	Options              store.ReportOptions `json:"options"`
+	QuarantineFilePath   *string             `json:"quarantine_file_path,omitempty"`
+	QuarantinedRows      int                 `json:"quarantined_rows"`
+	ErrorCode            *string             `json:"error_code,omitempty"`
+	Priority             int                 `json:"priority"`
+	Labels               []string            `json:"labels"`
 }
 
+func newReportResponse(report *store.Report) CreateReportResponse {
+	return CreateReportResponse{
+		Id:                 report.Id,
+		ReportType:         report.ReportType,
+		OutputFilePath:     report.OutputFilePath,
+		ErrorMessage:       report.ErrorMessage,
+		CreatedAt:          report.CreatedAt,
+		StartedAt:          report.StartedAt,
+		FailedAt:           report.FailedAt,
+		CompletedAt:        report.CompletedAt,
+		Status:             report.Status(),
+		Options:            report.Options.Val,
+		QuarantineFilePath: report.QuarantineFilePath,
+		QuarantinedRows:    report.QuarantinedRows,
+		ErrorCode:          report.ErrorCode,
+		Priority:           report.Priority,
+		Labels:             report.Labels,
+	}
+}
+
+const (
+	maxReportLabels     = 20
+	maxReportLabelBytes = 64
+)
+
 func (r CreateReportRequest) Validate() error {
 	if r.ReportType == "" {
 		return errors.New("report_type is required")
 	}
 
+	if r.Options.Enrich && r.ReportType != reports.MonstersReportType {
+		return fmt.Errorf("options.enrich is only supported for %s reports", reports.MonstersReportType)
+	}
+
+	if len(r.Labels) > maxReportLabels {
+		return fmt.Errorf("at most %d labels are allowed", maxReportLabels)
+	}
+
+	for _, label := range r.Labels {
+		if label == "" || len(label) > maxReportLabelBytes {
+			return fmt.Errorf("labels must be between 1 and %d bytes long", maxReportLabelBytes)
+		}
+	}
+
 	return nil
 }
 
@@ -239,51 +292,213 @@ func (s *Server) createReportHandler() http.HandlerFunc {
 			return NewErrWithStatus(err, http.StatusUnauthorized)
 		}
 
-		report, err := s.store.Reports.Create(r.Context(), user.Id, req.ReportType)
+		if req.ReportType != reports.MonstersReportType {
+			if _, err := s.store.DataSources.ByName(r.Context(), req.ReportType); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return NewErrWithStatus(fmt.Errorf("unknown report_type %s", req.ReportType), http.StatusBadRequest)
+				}
+				return NewErrWithStatus(err, http.StatusInternalServerError)
+			}
+		}
+
+		// quotas are checked before creating, so concurrent requests may overshoot them slightly
+		usage, err := s.usage(r.Context(), user.Id)
 		if err != nil {
 			return NewErrWithStatus(err, http.StatusInternalServerError)
 		}
 
-		// send to SQS to beb picked up by worker
-		sqsMessage := reports.SqsMessage{
-			UserId:   user.Id,
-			ReportId: report.Id,
+		if usage.DailyReportQuota > 0 && usage.ReportsToday >= usage.DailyReportQuota {
+			return NewErrWithStatus(fmt.Errorf("daily quota of %d reports reached, it resets at %s", usage.DailyReportQuota, usage.QuotaResetsAt.Format(time.RFC3339)), http.StatusTooManyRequests).WithData(usage)
+		}
+
+		if usage.StorageQuotaBytes > 0 && usage.OutputBytes >= usage.StorageQuotaBytes {
+			return NewErrWithStatus(fmt.Errorf("storage quota of %d bytes used up by %d bytes of reports", usage.StorageQuotaBytes, usage.OutputBytes), http.StatusForbidden).WithData(usage)
 		}
 
-		bytes, err := json.Marshal(sqsMessage)
+		// the message is written to the outbox with the report and relayed to the worker queue
+		requestId, _ := GetReqIdFromContext(r.Context())
+		report, err := s.store.Reports.CreateAndEnqueue(r.Context(), user.Id, req.ReportType, req.Options, req.Labels, func(report *store.Report) (string, error) {
+			return reports.NewReportBuildMessage(report, requestId, r.Header.Get("traceparent"))
+		})
 		if err != nil {
 			return NewErrWithStatus(err, http.StatusInternalServerError)
 		}
 
-		queueUrlOut, err := s.sqsClient.GetQueueUrl(r.Context(), &sqs.GetQueueUrlInput{
-			QueueName: aws.String(s.config.SqsQueue),
-		})
+		successResponse(w, http.StatusCreated, "", newReportResponse(report))
+
+		return nil
+	})
+}
+
+type ListReportsResponse struct {
+	Reports []CreateReportResponse `json:"reports"`
+	// NextCursor fetches the next page when passed as cursor, it is empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+const maxReportPageSize = 100
+
+// reportFilter parses the query of GET /api/v1/reports: status, report_type, label (repeated
+// for reports with all of them), created_after and created_before as exclusive RFC 3339
+// timestamps, sort as created_at or -created_at, limit and cursor.
+func reportFilter(query url.Values) (store.ReportFilter, error) {
+	filter := store.ReportFilter{
+		Status:     query.Get("status"),
+		ReportType: query.Get("report_type"),
+		Labels:     query["label"],
+		Limit:      store.DefaultReportPageSize,
+	}
+
+	if filter.Status != "" && !slices.Contains([]string{"pending", "processing", "completed", "failed"}, filter.Status) {
+		return filter, fmt.Errorf("status must be one of pending, processing, completed or failed, got %q", filter.Status)
+	}
+
+	for name, bound := range map[string]**time.Time{"created_after": &filter.CreatedAfter, "created_before": &filter.CreatedBefore} {
+		if raw := query.Get(name); raw != "" {
+			createdAt, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 timestamp, got %q", name, raw)
+			}
+			*bound = &createdAt
+		}
+	}
+
+	switch sort := query.Get("sort"); sort {
+	case "", "-created_at":
+	case "created_at":
+		filter.Ascending = true
+	default:
+		return filter, fmt.Errorf("sort must be created_at or -created_at, got %q", sort)
+	}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxReportPageSize {
+			return filter, fmt.Errorf("limit must be between 1 and %d, got %q", maxReportPageSize, raw)
+		}
+		filter.Limit = limit
+	}
+
+	if raw := query.Get("cursor"); raw != "" {
+		cursor, err := decodeCursor(raw)
+		if err != nil {
+			return filter, err
+		}
+		if cursor.Ascending != filter.Ascending {
+			return filter, errors.New("cursor belongs to a listing with a different sort")
+		}
+		filter.After = cursor
+	}
+
+	return filter, nil
+}
+
+// cursors are opaque to clients, they must pass them back with the same filters and sort.
+// Cursors of the other sort are rejected, other filters are not recorded.
+func encodeCursor(cursor *store.ReportCursor) (string, error) {
+	content, err := json.Marshal(cursor)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(content), nil
+}
+
+func decodeCursor(raw string) (*store.ReportCursor, error) {
+	var cursor store.ReportCursor
+
+	content, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil || json.Unmarshal(content, &cursor) != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	return &cursor, nil
+}
+
+func (s *Server) listReportsHandler() http.HandlerFunc {
+	return handleWithError(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(errors.New("unauthorized"), http.StatusUnauthorized)
+		}
+
+		filter, err := reportFilter(r.URL.Query())
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusBadRequest)
+		}
+
+		page, next, err := s.store.Reports.List(r.Context(), user.Id, filter)
 		if err != nil {
 			return NewErrWithStatus(err, http.StatusInternalServerError)
 		}
 
-		_, err = s.sqsClient.SendMessage(r.Context(), &sqs.SendMessageInput{
-			QueueUrl:    queueUrlOut.QueueUrl,
-			MessageBody: aws.String(string(bytes)),
-		})
+		response := ListReportsResponse{Reports: make([]CreateReportResponse, 0, len(page))}
+		for _, report := range page {
+			response.Reports = append(response.Reports, newReportResponse(&report))
+		}
+
+		if next != nil {
+			if response.NextCursor, err = encodeCursor(next); err != nil {
+				return NewErrWithStatus(err, http.StatusInternalServerError)
+			}
+		}
+
+		successResponse(w, http.StatusOK, "", response)
+		return nil
+	})
+}
+
+// UsageResponse is what a user holds against their quotas, a zero quota is unlimited.
+type UsageResponse struct {
+	OutputBytes       int64                    `json:"output_bytes"`
+	StorageQuotaBytes int64                    `json:"storage_quota_bytes"`
+	Reports           int                      `json:"reports"`
+	ReportsToday      int                      `json:"reports_today"`
+	DailyReportQuota  int                      `json:"daily_report_quota"`
+	QuotaResetsAt     time.Time                `json:"quota_resets_at"`
+	Daily             []store.DailyReportCount `json:"daily,omitempty"`
+}
+
+// usageHistoryDays is how many days of report counts GET /api/v1/me/usage returns
+const usageHistoryDays = 30
+
+// usage returns the usage of a user, days run from midnight utc.
+func (s *Server) usage(ctx context.Context, userId uuid.UUID) (*UsageResponse, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	usage, err := s.store.Reports.Usage(ctx, userId, today)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UsageResponse{
+		OutputBytes:       usage.OutputBytes,
+		StorageQuotaBytes: s.config.UserStorageQuota,
+		Reports:           usage.Reports,
+		ReportsToday:      usage.ReportsSince,
+		DailyReportQuota:  s.config.DailyReportQuota,
+		QuotaResetsAt:     today.Add(24 * time.Hour),
+	}, nil
+}
+
+func (s *Server) usageHandler() http.HandlerFunc {
+	return handleWithError(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(errors.New("unauthorized"), http.StatusUnauthorized)
+		}
+
+		usage, err := s.usage(r.Context(), user.Id)
 		if err != nil {
 			return NewErrWithStatus(err, http.StatusInternalServerError)
 		}
 
-		successResponse(w, http.StatusCreated, "", CreateReportResponse{
-			Id:                   report.Id,
-			ReportType:           report.ReportType,
-			OutputFilePath:       report.OutputFilePath,
-			DownloadUrl:          report.DownloadUrl,
-			DownloadUrlExpiresAt: report.DownloadUrlExpiresAt,
-			ErrorMessage:         report.ErrorMessage,
-			CreatedAt:            report.CreatedAt,
-			StartedAt:            report.StartedAt,
-			FailedAt:             report.FailedAt,
-			CompletedAt:          report.CompletedAt,
-			Status:               report.Status(),
-		})
+		since := usage.QuotaResetsAt.AddDate(0, 0, -usageHistoryDays)
+		usage.Daily, err = s.store.Reports.DailyReportCounts(r.Context(), user.Id, since)
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
 
+		successResponse(w, http.StatusOK, "", usage)
 		return nil
 	})
 }
@@ -309,45 +524,165 @@ func (s *Server) getReportHandler() http.HandlerFunc {
 			return NewErrWithStatus(err, http.StatusInternalServerError)
 		}
 
-		if report.CompletedAt != nil {
-			needsRefresh := report.DownloadUrlExpiresAt != nil && report.DownloadUrlExpiresAt.Before(time.Now())
-
-			if report.DownloadUrl == nil || needsRefresh {
-				// CREATE PRESIGNED URL
-				expiresAt := time.Now().Add(10 * time.Second)
-				signedUrl, err := s.preSignClient.PresignGetObject(r.Context(), &s3.GetObjectInput{
-					Bucket: aws.String(s.config.S3Bucket),
-					Key:    report.OutputFilePath,
-				}, func(options *s3.PresignOptions) {
-					options.Expires = time.Until(expiresAt)
-				})
-				if err != nil {
-					return NewErrWithStatus(err, http.StatusInternalServerError)
-				}
+		response := newReportResponse(report)
 
-				report.DownloadUrl = &signedUrl.URL
-				report.DownloadUrlExpiresAt = &expiresAt
-				report, err = s.store.Reports.Update(r.Context(), report)
-				if err != nil {
-					return NewErrWithStatus(err, http.StatusInternalServerError)
-				}
+		// urls are signed on every read and never stored, so a GET stays a read
+		if report.CompletedAt != nil && report.OutputFilePath != nil {
+			ttl, err := s.presignTtl(r)
+			if err != nil {
+				return NewErrWithStatus(err, http.StatusBadRequest)
+			}
+
+			expiresAt := time.Now().Add(ttl)
+			signedUrl, err := s.storage.PresignGet(r.Context(), *report.OutputFilePath, ttl)
+			switch {
+			case errors.Is(err, storage.ErrPresignUnsupported):
+				// e.g. encrypted files, which the api decrypts while streaming them
+				downloadUrl := s.config.PublicUrl() + "/api/v1/reports/" + report.Id.String() + "/download"
+				response.DownloadUrl = &downloadUrl
+			case err != nil:
+				return NewErrWithStatus(err, http.StatusInternalServerError)
+			default:
+				response.DownloadUrl = &signedUrl
+				response.DownloadUrlExpiresAt = &expiresAt
 			}
 		}
 
-		successResponse(w, http.StatusOK, "", CreateReportResponse{
-			Id:                   report.Id,
-			ReportType:           report.ReportType,
-			OutputFilePath:       report.OutputFilePath,
-			DownloadUrl:          report.DownloadUrl,
-			DownloadUrlExpiresAt: report.DownloadUrlExpiresAt,
-			ErrorMessage:         report.ErrorMessage,
-			CreatedAt:            report.CreatedAt,
-			StartedAt:            report.StartedAt,
-			FailedAt:             report.FailedAt,
-			CompletedAt:          report.CompletedAt,
-			Status:               report.Status(),
-		})
+		successResponse(w, http.StatusOK, "", response)
+		return nil
+	})
+}
+
+// presignTtl is how long presigned urls handed out for r stay valid: the url_ttl query
+// parameter, a duration such as 10m or a number of seconds, capped at MAX_PRESIGN_TTL.
+func (s *Server) presignTtl(r *http.Request) (time.Duration, error) {
+	raw := r.URL.Query().Get("url_ttl")
+	if raw == "" {
+		return min(s.config.PresignTtl, s.config.MaxPresignTtl), nil
+	}
+
+	ttl, err := time.ParseDuration(raw)
+	if seconds, atoiErr := strconv.Atoi(raw); atoiErr == nil {
+		ttl, err = time.Duration(seconds)*time.Second, nil
+	}
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("url_ttl must be a positive duration such as 10m or a number of seconds, got %q", raw)
+	}
+
+	return min(ttl, s.config.MaxPresignTtl), nil
+}
+
+const (
+	// DownloadModeRedirect redirects downloads to a presigned url, storages without them are streamed
+	DownloadModeRedirect = "redirect"
+	// DownloadModeStream streams downloads through the api
+	DownloadModeStream = "stream"
+
+	// presigned urls are used right away by the redirected client
+	downloadRedirectExpiry = time.Minute
+)
+
+// downloadReportHandler downloads the output of a completed report owned by the user. Depending
+// on DOWNLOAD_MODE it redirects to a presigned url or streams the file through the api, with
+// support for Range and conditional requests.
+func (s *Server) downloadReportHandler() http.HandlerFunc {
+	return handleWithError(func(w http.ResponseWriter, r *http.Request) error {
+		reportId, err := uuid.Parse(r.PathValue("report"))
+		if err != nil {
+			return NewErrWithStatus(err, http.StatusBadRequest)
+		}
+
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(errors.New("unauthorized"), http.StatusUnauthorized)
+		}
+
+		report, err := s.store.Reports.ByPrimaryKey(r.Context(), reportId, user.Id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return NewErrWithStatus(err, http.StatusNotFound)
+			}
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+
+		if report.CompletedAt == nil || report.OutputFilePath == nil {
+			return NewErrWithStatus(fmt.Errorf("report %s is %s", report.Id, report.Status()), http.StatusConflict)
+		}
+
+		if s.config.DownloadMode == DownloadModeRedirect {
+			signedUrl, err := s.storage.PresignGet(r.Context(), *report.OutputFilePath, downloadRedirectExpiry)
+			if err == nil {
+				http.Redirect(w, r, signedUrl, http.StatusFound)
+				return nil
+			}
+			if !errors.Is(err, storage.ErrPresignUnsupported) {
+				return NewErrWithStatus(err, http.StatusInternalServerError)
+			}
+		}
+
+		info, err := s.storage.Stat(r.Context(), *report.OutputFilePath)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return NewErrWithStatus(err, http.StatusNotFound)
+			}
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+
+		body := storage.NewRangeReader(r.Context(), s.storage, info)
+		defer body.Close()
+
+		// the headers are computed rather than read from the file, which may predate its metadata
+		metadata := reports.OutputMetadata(report)
+		setMetadataHeaders(w, metadata)
+		w.Header().Set("ETag", info.ETag)
+		w.Header().Set("Cache-Control", "private")
+		http.ServeContent(w, r, reports.OutputFilename(report), info.LastModified, body)
+		return nil
+	})
+}
+
+// downloadFileHandler serves report files of the filesystem storage backend, which has
+// no presigned urls of its own. The url signature stands in for the access token.
+func (s *Server) downloadFileHandler() http.HandlerFunc {
+	return handleWithError(func(w http.ResponseWriter, r *http.Request) error {
+		fileStorage, ok := s.storage.(*storage.FileStorage)
+		if !ok {
+			return NewErrWithStatus(errors.New("file downloads are not served by this storage backend"), http.StatusNotFound)
+		}
+
+		key := r.PathValue("key")
+		if err := fileStorage.Verify(key, r.URL.Query()); err != nil {
+			return NewErrWithStatus(err, http.StatusForbidden)
+		}
+
+		body, info, err := fileStorage.Get(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return NewErrWithStatus(fmt.Errorf("file %s not found", key), http.StatusNotFound)
+			}
+			return NewErrWithStatus(err, http.StatusInternalServerError)
+		}
+		defer body.Close()
 
+		if info.Metadata.ContentDisposition == "" {
+			info.Metadata.ContentDisposition = mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(key)})
+		}
+		setMetadataHeaders(w, info.Metadata)
+		w.Header().Set("ETag", info.ETag)
+		http.ServeContent(w, r, path.Base(key), info.LastModified, body.(io.ReadSeeker))
 		return nil
 	})
 }
+
+// setMetadataHeaders sets the content headers of a stored file.
+func setMetadataHeaders(w http.ResponseWriter, metadata storage.Metadata) {
+	if metadata.ContentType != "" {
+		w.Header().Set("Content-Type", metadata.ContentType)
+	}
+	if metadata.ContentEncoding != "" {
+		w.Header().Set("Content-Encoding", metadata.ContentEncoding)
+	}
+	if metadata.ContentDisposition != "" {
+		w.Header().Set("Content-Disposition", metadata.ContentDisposition)
+	}
+}
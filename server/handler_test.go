@@ -0,0 +1,111 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/config"
+	"github.com/victor-devv/report-gen/server"
+	"github.com/victor-devv/report-gen/store"
+)
+
+func TestReportFilter(t *testing.T) {
+	filter, err := server.ReportFilter(url.Values{})
+	require.NoError(t, err)
+	require.Equal(t, store.ReportFilter{Limit: store.DefaultReportPageSize}, filter)
+
+	filter, err = server.ReportFilter(url.Values{
+		"status":         {"failed"},
+		"report_type":    {"monsters"},
+		"label":          {"weekly", "eu"},
+		"created_after":  {"2025-01-01T00:00:00Z"},
+		"created_before": {"2025-02-01T00:00:00+01:00"},
+		"sort":           {"created_at"},
+		"limit":          {"100"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "failed", filter.Status)
+	require.Equal(t, "monsters", filter.ReportType)
+	require.Equal(t, []string{"weekly", "eu"}, filter.Labels)
+	require.True(t, filter.CreatedAfter.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+	require.True(t, filter.CreatedBefore.Equal(time.Date(2025, 1, 31, 23, 0, 0, 0, time.UTC)))
+	require.True(t, filter.Ascending)
+	require.Equal(t, 100, filter.Limit)
+
+	for name, query := range map[string]url.Values{
+		"unknown status":        {"status": {"done"}},
+		"invalid created_after": {"created_after": {"yesterday"}},
+		"date without time":     {"created_before": {"2025-01-01"}},
+		"unknown sort":          {"sort": {"name"}},
+		"zero limit":            {"limit": {"0"}},
+		"limit too large":       {"limit": {"101"}},
+		"limit not a number":    {"limit": {"ten"}},
+		"invalid cursor":        {"cursor": {"not a cursor"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := server.ReportFilter(query)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestReportCursor(t *testing.T) {
+	cursor := &store.ReportCursor{
+		CreatedAt: time.Date(2025, 1, 31, 12, 0, 0, 123456000, time.UTC),
+		Id:        uuid.New(),
+	}
+
+	encoded, err := server.EncodeCursor(cursor)
+	require.NoError(t, err)
+
+	filter, err := server.ReportFilter(url.Values{"cursor": {encoded}})
+	require.NoError(t, err)
+	require.Equal(t, cursor.Id, filter.After.Id)
+	require.True(t, cursor.CreatedAt.Equal(filter.After.CreatedAt))
+	require.False(t, filter.After.Ascending)
+
+	// a cursor of a newest first listing can't continue an oldest first one
+	_, err = server.ReportFilter(url.Values{"cursor": {encoded}, "sort": {"created_at"}})
+	require.Error(t, err)
+
+	cursor.Ascending = true
+	encoded, err = server.EncodeCursor(cursor)
+	require.NoError(t, err)
+	filter, err = server.ReportFilter(url.Values{"cursor": {encoded}, "sort": {"created_at"}})
+	require.NoError(t, err)
+	require.True(t, filter.After.Ascending)
+}
+
+func TestPresignTtl(t *testing.T) {
+	conf := &config.Config{PresignTtl: 5 * time.Minute, MaxPresignTtl: time.Hour}
+	s := server.New(conf, nil, nil, nil, nil, nil)
+
+	for query, expected := range map[string]time.Duration{
+		"":              5 * time.Minute,
+		"?url_ttl=10m":  10 * time.Minute,
+		"?url_ttl=90":   90 * time.Second,
+		"?url_ttl=1h":   time.Hour,
+		"?url_ttl=2h":   time.Hour,
+		"?url_ttl=7200": time.Hour,
+	} {
+		ttl, err := s.PresignTtl(httptest.NewRequest(http.MethodGet, "/api/v1/reports/1"+query, nil))
+		require.NoError(t, err, query)
+		require.Equal(t, expected, ttl, query)
+	}
+
+	for _, query := range []string{"?url_ttl=0", "?url_ttl=-1m", "?url_ttl=-5", "?url_ttl=soon"} {
+		_, err := s.PresignTtl(httptest.NewRequest(http.MethodGet, "/api/v1/reports/1"+query, nil))
+		require.Error(t, err, query)
+	}
+
+	// the default never exceeds the cap
+	conf.PresignTtl = 2 * time.Hour
+	ttl, err := s.PresignTtl(httptest.NewRequest(http.MethodGet, "/api/v1/reports/1", nil))
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, ttl)
+}
@@ -22,6 +22,7 @@ func (s Status) String() string {
 type ErrWithStatus struct {
 	status int
 	err    error
+	data   any
 }
 
 func (e *ErrWithStatus) Error() string {
@@ -32,6 +33,12 @@ func NewErrWithStatus(err error, status int) *ErrWithStatus {
 	return &ErrWithStatus{err: err, status: status}
 }
 
+// WithData adds data to the error response, e.g. the usage that exceeded a quota.
+func (e *ErrWithStatus) WithData(data any) *ErrWithStatus {
+	e.data = data
+	return e
+}
+
 type ApiResponse[T any] struct {
 	Status  string `json:"status"`
 	Message string `json:"message,omitempty"`
@@ -101,6 +108,10 @@ func handleWithError(f func(w http.ResponseWriter, r *http.Request) error) http.
 				}
 			}
 			slog.Error("error executing handler", "error", err, "status", status, "message", msg)
+			if e, ok := err.(*ErrWithStatus); ok && e.data != nil {
+				errorResponse(w, Error, err.Error(), status, e.data)
+				return
+			}
 			errorResponse(w, Error, err.Error(), status, (*struct{})(nil))
 		}
 	}
@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"io"
 	"log/slog"
 	"net/http"
@@ -10,6 +11,8 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/victor-devv/report-gen/config"
+	"github.com/victor-devv/report-gen/storage"
 	"github.com/victor-devv/report-gen/store"
 )
 
@@ -29,6 +32,40 @@ func sanitizeHeaders(headers http.Header) map[string]string {
 	return safeHeaders
 }
 
+// sanitizeBody redacts secrets such as passwords, tokens and data source credentials
+// from a json body before it is logged. Bodies that aren't json are logged as is.
+func sanitizeBody(body []byte) string {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return string(body)
+	}
+
+	sanitized, err := json.Marshal(redactSecrets(value))
+	if err != nil {
+		return string(body)
+	}
+	return string(sanitized)
+}
+
+func redactSecrets(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for k, field := range v {
+			switch strings.ToLower(k) {
+			case "password", "access_token", "refresh_token", "auth_header_value":
+				v[k] = "[REDACTED]"
+			default:
+				v[k] = redactSecrets(field)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactSecrets(item)
+		}
+	}
+	return value
+}
+
 type responseWriter struct {
 	http.ResponseWriter
 	status int
@@ -49,7 +86,10 @@ func (rw *responseWriter) WriteHeader(code int) {
 }
 
 func (rw *responseWriter) Write(b []byte) (int, error) {
-	rw.body.Write(b)
+	// file downloads would flood the log
+	if !strings.HasPrefix(rw.Header().Get("Content-Disposition"), "attachment") {
+		rw.body.Write(b)
+	}
 	return rw.ResponseWriter.Write(b)
 }
 
@@ -59,6 +99,11 @@ func ContextWithReqId(ctx context.Context, requestID string) context.Context {
 	return context.WithValue(ctx, reqIdCtxKey{}, requestID)
 }
 
+func GetReqIdFromContext(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(reqIdCtxKey{}).(string)
+	return requestID, ok
+}
+
 func NewLoggerMiddleware(logger *slog.Logger) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -75,7 +120,7 @@ func NewLoggerMiddleware(logger *slog.Logger) func(next http.Handler) http.Handl
 
 			startTime := time.Now()
 
-			requestBodyStr := string(requestBody)
+			requestBodyStr := sanitizeBody(requestBody)
 
 			logger.Info("HTTP Request",
 				"request_id", requestID,
@@ -90,7 +135,7 @@ func NewLoggerMiddleware(logger *slog.Logger) func(next http.Handler) http.Handl
 
 			duration := time.Since(startTime)
 
-			responseBodyStr := wrappedWriter.body.String()
+			responseBodyStr := sanitizeBody(wrappedWriter.body.Bytes())
 
 			logger.Info("HTTP Response",
 				"request_id", requestID,
@@ -127,6 +172,12 @@ func NewAuthMiddleware(jwtManager *JwtManager, userStore *store.UserStore) func(
 				return
 			}
 
+			// file downloads are authorized by the signature of the url instead
+			if strings.HasPrefix(r.URL.EscapedPath(), storage.FilesDownloadPath) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			authHeader := r.Header.Get("Authorization")
 			var access_token string
 
@@ -177,3 +228,23 @@ func NewAuthMiddleware(jwtManager *JwtManager, userStore *store.UserStore) func(
 		})
 	}
 }
+
+// NewAdminMiddleware only lets users listed in ADMIN_EMAILS through. It must run after the auth middleware.
+func NewAdminMiddleware(conf *config.Config) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := GetUserFromContext(r.Context())
+			if !ok {
+				errorResponse(w, Error, "Unauthorized!", http.StatusUnauthorized, (*struct{})(nil))
+				return
+			}
+
+			if !conf.IsAdmin(user.Email) {
+				errorResponse(w, Error, "Forbidden!", http.StatusForbidden, (*struct{})(nil))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package server_test
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/server"
+)
+
+func TestLoggerMiddlewareRedactsSecrets(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	handler := server.NewLoggerMiddleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the handler still sees the original body
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Contains(t, string(body), "hunter2")
+
+		w.Write([]byte(`{"data":{"access_token":"access-secret","refresh_token":"refresh-secret","token_type":"Bearer"}}`))
+	}))
+
+	body := `{"name":"inventory","auth_header_name":"X-Api-Key","auth_header_value":"hunter2","headers":[{"password":"hunter3"}]}`
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/admin/data-sources", strings.NewReader(body)))
+
+	for _, secret := range []string{"hunter2", "hunter3", "access-secret", "refresh-secret"} {
+		require.NotContains(t, logs.String(), secret)
+	}
+	require.Contains(t, logs.String(), "X-Api-Key")
+	require.Contains(t, logs.String(), "Bearer")
+}
@@ -8,29 +8,29 @@ import (
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/victor-devv/report-gen/config"
+	"github.com/victor-devv/report-gen/queue"
+	"github.com/victor-devv/report-gen/storage"
 	"github.com/victor-devv/report-gen/store"
 )
 
 type Server struct {
-	config        *config.Config
-	logger        *slog.Logger
-	store         *store.Store
-	jwtManager    *JwtManager
-	sqsClient     *sqs.Client
-	preSignClient *s3.PresignClient
+	config     *config.Config
+	logger     *slog.Logger
+	store      *store.Store
+	jwtManager *JwtManager
+	queue      queue.Queue
+	storage    storage.Storage
 }
 
-func New(config *config.Config, logger *slog.Logger, store *store.Store, jwtManager *JwtManager, sqsClient *sqs.Client, preSignClient *s3.PresignClient) *Server {
+func New(config *config.Config, logger *slog.Logger, store *store.Store, jwtManager *JwtManager, messageQueue queue.Queue, reportStorage storage.Storage) *Server {
 	return &Server{
-		config:        config,
-		logger:        logger,
-		store:         store,
-		jwtManager:    jwtManager,
-		sqsClient:     sqsClient,
-		preSignClient: preSignClient,
+		config:     config,
+		logger:     logger,
+		store:      store,
+		jwtManager: jwtManager,
+		queue:      messageQueue,
+		storage:    reportStorage,
 	}
 }
 
@@ -58,7 +58,24 @@ func (s *Server) Start(ctx context.Context) error {
 	mux.HandleFunc("POST /api/v1/auth/signin", s.signInHandler())
 	mux.HandleFunc("POST /api/v1/auth/token/refresh", s.refreshTokenHandler())
 	mux.HandleFunc("POST /api/v1/reports", s.createReportHandler())
+	mux.HandleFunc("GET /api/v1/reports", s.listReportsHandler())
 	mux.HandleFunc("GET /api/v1/reports/{report}", s.getReportHandler())
+	mux.HandleFunc("GET /api/v1/reports/{report}/download", s.downloadReportHandler())
+	mux.HandleFunc("GET /api/v1/me/usage", s.usageHandler())
+	if _, ok := s.storage.(*storage.FileStorage); ok {
+		mux.HandleFunc("GET "+storage.FilesDownloadPath+"{key...}", s.downloadFileHandler())
+	}
+
+	adminMiddleware := NewAdminMiddleware(s.config)
+	mux.Handle("POST /api/v1/admin/data-sources", adminMiddleware(s.createDataSourceHandler()))
+	mux.Handle("GET /api/v1/admin/data-sources", adminMiddleware(s.listDataSourcesHandler()))
+	mux.Handle("DELETE /api/v1/admin/data-sources/{dataSource}", adminMiddleware(s.deleteDataSourceHandler()))
+	mux.Handle("GET /api/v1/admin/dead-letters", adminMiddleware(s.listDeadLettersHandler()))
+	mux.Handle("POST /api/v1/admin/dead-letters/{deadLetter}/redrive", adminMiddleware(s.redriveDeadLetterHandler()))
+	mux.Handle("DELETE /api/v1/admin/dead-letters/{deadLetter}", adminMiddleware(s.deleteDeadLetterHandler()))
+	mux.Handle("DELETE /api/v1/admin/dead-letters", adminMiddleware(s.purgeDeadLettersHandler()))
+	mux.Handle("POST /api/v1/admin/reports/{report}/priority", adminMiddleware(s.setReportPriorityHandler()))
+	mux.Handle("POST /api/v1/admin/jobs", adminMiddleware(s.enqueueJobHandler()))
 
 	loggerMiddleware := NewLoggerMiddleware(s.logger)
 	authMiddleware := NewAuthMiddleware(s.jwtManager, s.store.Users)
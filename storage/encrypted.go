@@ -0,0 +1,441 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/victor-devv/report-gen/store"
+)
+
+const (
+	// encrypted files start with a magic and the nonce prefix of their chunks
+	encryptionMagic = "RGE1"
+	noncePrefixSize = 7
+	headerSize      = len(encryptionMagic) + noncePrefixSize
+	chunkSize       = 64 * 1024
+	tagSize         = 16
+)
+
+var (
+	// ErrPresignUnsupported is returned by storages whose files can only be downloaded through the api.
+	ErrPresignUnsupported = errors.New("presigned urls are not supported by this storage")
+	ErrCorruptFile        = errors.New("encrypted file is corrupt")
+)
+
+// DataKeys stores the wrapped per user data keys.
+type DataKeys interface {
+	Create(ctx context.Context, userId uuid.UUID, wrappedKey []byte, masterKeyId string) (*store.DataKey, error)
+	ByUserId(ctx context.Context, userId uuid.UUID) (*store.DataKey, error)
+}
+
+// EncryptedStorage encrypts files with the data key of the user owning them before
+// handing them to the wrapped storage. Files are split into chunks sealed with
+// AES-256-GCM, so they are streamed rather than held in memory. The ciphertext is
+// useless to clients, so there are no presigned urls and downloads go through the api.
+// Files without the encryption magic are rejected as corrupt, unless readUnencrypted
+// is set while files written before encryption was enabled are still around. Stripping
+// the header of an encrypted file then bypasses its authentication, so only set it for
+// the migration.
+type EncryptedStorage struct {
+	storage         Storage
+	keyring         *Keyring
+	dataKeys        DataKeys
+	readUnencrypted bool
+
+	// unwrapped data keys, keyed by user id and master key id
+	cache sync.Map
+}
+
+func NewEncryptedStorage(storage Storage, keyring *Keyring, dataKeys DataKeys, readUnencrypted bool) *EncryptedStorage {
+	return &EncryptedStorage{
+		storage:         storage,
+		keyring:         keyring,
+		dataKeys:        dataKeys,
+		readUnencrypted: readUnencrypted,
+	}
+}
+
+// dataKey returns the data key of the owner of key, creating one on first use.
+func (s *EncryptedStorage) dataKey(ctx context.Context, key string) ([]byte, error) {
+	userId, err := KeyOwner(key)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := s.dataKeys.ByUserId(ctx, userId)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		dataKey := make([]byte, 32)
+		if _, err := rand.Read(dataKey); err != nil {
+			return nil, fmt.Errorf("failed to generate data key: %w", err)
+		}
+
+		wrapped, masterKeyId, err := s.keyring.Wrap(dataKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if stored, err = s.dataKeys.Create(ctx, userId, wrapped, masterKeyId); err != nil {
+			return nil, err
+		}
+	}
+
+	cacheKey := stored.UserId.String() + "/" + stored.MasterKeyId
+	if dataKey, ok := s.cache.Load(cacheKey); ok {
+		return dataKey.([]byte), nil
+	}
+
+	dataKey, err := s.keyring.Unwrap(stored.WrappedKey, stored.MasterKeyId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data key for user %s: %w", userId, err)
+	}
+	s.cache.Store(cacheKey, dataKey)
+
+	return dataKey, nil
+}
+
+func (s *EncryptedStorage) Put(ctx context.Context, key string, body io.Reader, size int64, metadata Metadata) error {
+	dataKey, err := s.dataKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		encrypter, err := newEncryptWriter(writer, dataKey)
+		if err != nil {
+			writer.CloseWithError(err)
+			return
+		}
+
+		if _, err := io.Copy(encrypter, body); err != nil {
+			writer.CloseWithError(err)
+			return
+		}
+
+		writer.CloseWithError(encrypter.Close())
+	}()
+	defer reader.Close()
+
+	return s.storage.Put(ctx, key, reader, EncryptedSize(size), metadata)
+}
+
+func (s *EncryptedStorage) UpdateMetadata(ctx context.Context, key string, metadata Metadata) error {
+	return s.storage.UpdateMetadata(ctx, key, metadata)
+}
+
+func (s *EncryptedStorage) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	body, info, err := s.storage.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(body, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		body.Close()
+		return nil, nil, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+
+	if !isEncrypted(header[:n]) {
+		if !s.readUnencrypted {
+			body.Close()
+			return nil, nil, fmt.Errorf("failed to decrypt %s: %w", key, ErrCorruptFile)
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(header[:n]), body), body}, info, nil
+	}
+
+	dataKey, err := s.dataKey(ctx, key)
+	if err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+
+	decrypter, err := newChunkReader(body, dataKey, header, 0, -1)
+	if err != nil {
+		body.Close()
+		return nil, nil, fmt.Errorf("failed to decrypt %s: %w", key, err)
+	}
+
+	info.Size = PlaintextSize(info.Size)
+	return decrypter, info, nil
+}
+
+// GetRange decrypts only the chunks overlapping the range.
+func (s *EncryptedStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	header, err := s.header(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if header == nil {
+		return s.storage.GetRange(ctx, key, offset, length)
+	}
+
+	dataKey, err := s.dataKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	first, last := offset/chunkSize, (offset+length-1)/chunkSize
+	chunks := last - first + 1
+	body, err := s.storage.GetRange(ctx, key, int64(headerSize)+first*(chunkSize+tagSize), chunks*(chunkSize+tagSize))
+	if err != nil {
+		return nil, err
+	}
+
+	decrypter, err := newChunkReader(body, dataKey, header, uint32(first), chunks)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("failed to decrypt %s: %w", key, err)
+	}
+
+	if _, err := io.CopyN(io.Discard, decrypter, offset-first*chunkSize); err != nil {
+		decrypter.Close()
+		return nil, fmt.Errorf("failed to decrypt %s: %w", key, err)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(decrypter, length), decrypter}, nil
+}
+
+func (s *EncryptedStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	info, err := s.storage.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size < int64(headerSize) {
+		if !s.readUnencrypted {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", key, ErrCorruptFile)
+		}
+		return info, nil
+	}
+
+	header, err := s.header(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if header != nil {
+		info.Size = PlaintextSize(info.Size)
+	}
+	return info, nil
+}
+
+// header reads the header of an encrypted file. It is nil for files written before
+// encryption was enabled when they may be read, they are corrupt otherwise.
+func (s *EncryptedStorage) header(ctx context.Context, key string) ([]byte, error) {
+	body, err := s.storage.GetRange(ctx, key, 0, int64(headerSize))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(body, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+
+	if !isEncrypted(header[:n]) {
+		if !s.readUnencrypted {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", key, ErrCorruptFile)
+		}
+		return nil, nil
+	}
+	return header, nil
+}
+
+func isEncrypted(header []byte) bool {
+	return len(header) == headerSize && string(header[:len(encryptionMagic)]) == encryptionMagic
+}
+
+func (s *EncryptedStorage) Delete(ctx context.Context, key string) error {
+	return s.storage.Delete(ctx, key)
+}
+
+func (s *EncryptedStorage) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "", ErrPresignUnsupported
+}
+
+// EncryptedSize is the size of a plaintext of size bytes once encrypted. Every chunk
+// carries a tag and the last chunk is always short, possibly empty, to mark the end.
+func EncryptedSize(size int64) int64 {
+	return int64(headerSize) + size + (size/chunkSize+1)*tagSize
+}
+
+// PlaintextSize is the inverse of EncryptedSize.
+func PlaintextSize(size int64) int64 {
+	size -= int64(headerSize)
+	return size - (size/(chunkSize+tagSize)+1)*tagSize
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	seal    func(dst, nonce, plaintext, additionalData []byte) []byte
+	header  []byte
+	buffer  []byte
+	counter uint32
+}
+
+func newEncryptWriter(w io.Writer, dataKey []byte) (*encryptWriter, error) {
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, encryptionMagic)
+	if _, err := rand.Read(header[len(encryptionMagic):]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{w: w, seal: aead.Seal, header: header, buffer: make([]byte, 0, chunkSize)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data arrives, the last chunk must be short
+		if len(e.buffer) == chunkSize {
+			if err := e.flush(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := min(chunkSize-len(e.buffer), len(p))
+		e.buffer = append(e.buffer, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) flush(last bool) error {
+	nonce := chunkNonce(e.header[len(encryptionMagic):], e.counter, last)
+	if _, err := e.w.Write(e.seal(nil, nonce, e.buffer, e.header)); err != nil {
+		return err
+	}
+	e.counter++
+	e.buffer = e.buffer[:0]
+	return nil
+}
+
+// Close seals the remaining data as the last chunk.
+func (e *encryptWriter) Close() error {
+	if len(e.buffer) == chunkSize {
+		if err := e.flush(false); err != nil {
+			return err
+		}
+	}
+	return e.flush(true)
+}
+
+type decryptReader struct {
+	r       io.ReadCloser
+	open    func(dst, nonce, ciphertext, additionalData []byte) ([]byte, error)
+	header  []byte
+	chunk   []byte
+	plain   []byte
+	counter uint32
+	// chunks left to read when decrypting a range, negative reads to the end
+	chunks int64
+	done   bool
+}
+
+// newChunkReader decrypts chunks chunks of a file with header, read from r starting at chunk counter.
+func newChunkReader(r io.ReadCloser, dataKey, header []byte, counter uint32, chunks int64) (*decryptReader, error) {
+	if string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, ErrCorruptFile
+	}
+
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{r: r, open: aead.Open, header: header, chunk: make([]byte, chunkSize+tagSize), counter: counter, chunks: chunks}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk, a short chunk is the last one.
+func (d *decryptReader) next() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	last := false
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		last = true
+	case err != nil:
+		return err
+	}
+
+	if n < tagSize {
+		return ErrCorruptFile
+	}
+
+	nonce := chunkNonce(d.header[len(encryptionMagic):], d.counter, last)
+	plain, err := d.open(d.chunk[:0], nonce, d.chunk[:n], d.header)
+	if err != nil {
+		return ErrCorruptFile
+	}
+
+	d.plain = plain
+	d.counter++
+	d.chunks--
+	d.done = last || d.chunks == 0
+	return nil
+}
+
+func (d *decryptReader) Close() error {
+	return d.r.Close()
+}
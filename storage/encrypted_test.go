@@ -0,0 +1,203 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/storage"
+	"github.com/victor-devv/report-gen/store"
+)
+
+type memoryDataKeys struct {
+	mu   sync.Mutex
+	keys map[uuid.UUID]*store.DataKey
+}
+
+func (m *memoryDataKeys) Create(ctx context.Context, userId uuid.UUID, wrappedKey []byte, masterKeyId string) (*store.DataKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.keys[userId]; !ok {
+		m.keys[userId] = &store.DataKey{UserId: userId, WrappedKey: wrappedKey, MasterKeyId: masterKeyId}
+	}
+	return m.keys[userId], nil
+}
+
+func (m *memoryDataKeys) ByUserId(ctx context.Context, userId uuid.UUID) (*store.DataKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if dataKey, ok := m.keys[userId]; ok {
+		return dataKey, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func masterKey(t *testing.T) []byte {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+func TestEncryptedStorage(t *testing.T) {
+	ctx := context.Background()
+	keyring, err := storage.NewKeyring(masterKey(t))
+	require.NoError(t, err)
+
+	plain := storage.NewMemoryStorage()
+	dataKeys := &memoryDataKeys{keys: make(map[uuid.UUID]*store.DataKey)}
+	encrypted := storage.NewEncryptedStorage(plain, keyring, dataKeys, false)
+	key := storage.ReportKey(uuid.New(), uuid.New())
+
+	// sizes around the chunk boundaries
+	for _, size := range []int{0, 1, 64*1024 - 1, 64 * 1024, 64*1024 + 1, 3*64*1024 + 17} {
+		content := make([]byte, size)
+		_, err := rand.Read(content)
+		require.NoError(t, err)
+
+		require.NoError(t, encrypted.Put(ctx, key, bytes.NewReader(content), int64(size), storage.Metadata{}))
+
+		stored, err := plain.Stat(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, storage.EncryptedSize(int64(size)), stored.Size)
+		require.Equal(t, int64(size), storage.PlaintextSize(stored.Size))
+
+		reader, info, err := encrypted.Get(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, int64(size), info.Size)
+		decrypted, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		require.Equal(t, content, decrypted)
+	}
+
+	_, err = encrypted.PresignGet(ctx, key, 0)
+	require.ErrorIs(t, err, storage.ErrPresignUnsupported)
+
+	// keys without an owner can't be encrypted
+	require.ErrorIs(t, encrypted.Put(ctx, "reports/1.csv.gz", bytes.NewReader(nil), 0, storage.Metadata{}), storage.ErrInvalidKey)
+}
+
+func TestEncryptedStorageDetectsTampering(t *testing.T) {
+	ctx := context.Background()
+	keyring, err := storage.NewKeyring(masterKey(t))
+	require.NoError(t, err)
+
+	plain := storage.NewMemoryStorage()
+	encrypted := storage.NewEncryptedStorage(plain, keyring, &memoryDataKeys{keys: make(map[uuid.UUID]*store.DataKey)}, false)
+	key := storage.ReportKey(uuid.New(), uuid.New())
+
+	content := bytes.Repeat([]byte("id,name\n1,link\n"), 10000)
+	require.NoError(t, encrypted.Put(ctx, key, bytes.NewReader(content), int64(len(content)), storage.Metadata{}))
+
+	reader, _, err := plain.Get(ctx, key)
+	require.NoError(t, err)
+	ciphertext, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	for name, tampered := range map[string][]byte{
+		"flipped bit": func() []byte {
+			tampered := bytes.Clone(ciphertext)
+			tampered[len(tampered)/2] ^= 1
+			return tampered
+		}(),
+		"truncated":          ciphertext[:len(ciphertext)-100],
+		"last chunk dropped": ciphertext[:11+64*1024+16],
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, plain.Put(ctx, key, bytes.NewReader(tampered), int64(len(tampered)), storage.Metadata{}))
+
+			reader, _, err := encrypted.Get(ctx, key)
+			require.NoError(t, err)
+			_, err = io.ReadAll(reader)
+			require.ErrorIs(t, err, storage.ErrCorruptFile)
+		})
+	}
+}
+
+func TestEncryptedStorageReadsUnencryptedFiles(t *testing.T) {
+	ctx := context.Background()
+	keyring, err := storage.NewKeyring(masterKey(t))
+	require.NoError(t, err)
+
+	plain := storage.NewMemoryStorage()
+	dataKeys := &memoryDataKeys{keys: make(map[uuid.UUID]*store.DataKey)}
+	encrypted := storage.NewEncryptedStorage(plain, keyring, dataKeys, true)
+	strict := storage.NewEncryptedStorage(plain, keyring, dataKeys, false)
+
+	// files written before encryption was enabled, including ones shorter than the header
+	for _, content := range [][]byte{[]byte("id,name\n1,link\n"), []byte("id")} {
+		key := storage.ReportKey(uuid.New(), uuid.New())
+		require.NoError(t, plain.Put(ctx, key, bytes.NewReader(content), int64(len(content)), storage.Metadata{}))
+
+		reader, info, err := encrypted.Get(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(content)), info.Size)
+		read, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		require.Equal(t, content, read)
+
+		info, err = encrypted.Stat(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(content)), info.Size)
+
+		reader, err = encrypted.GetRange(ctx, key, 1, 1)
+		require.NoError(t, err)
+		read, err = io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		require.Equal(t, content[1:2], read)
+
+		// without the migration setting they could be encrypted files with a stripped header
+		_, _, err = strict.Get(ctx, key)
+		require.ErrorIs(t, err, storage.ErrCorruptFile)
+		_, err = strict.Stat(ctx, key)
+		require.ErrorIs(t, err, storage.ErrCorruptFile)
+		_, err = strict.GetRange(ctx, key, 1, 1)
+		require.ErrorIs(t, err, storage.ErrCorruptFile)
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	oldKey, newKey := masterKey(t), masterKey(t)
+
+	oldKeyring, err := storage.NewKeyring(oldKey)
+	require.NoError(t, err)
+
+	dataKey := masterKey(t)
+	wrapped, oldId, err := oldKeyring.Wrap(dataKey)
+	require.NoError(t, err)
+	require.Equal(t, storage.MasterKeyId(oldKey), oldId)
+
+	// after rotation the old key still unwraps, new data keys use the new key
+	rotatedKeyring, err := storage.NewKeyring(newKey, oldKey)
+	require.NoError(t, err)
+
+	unwrapped, err := rotatedKeyring.Unwrap(wrapped, oldId)
+	require.NoError(t, err)
+	require.Equal(t, dataKey, unwrapped)
+
+	rewrapped, newId, err := rotatedKeyring.Wrap(unwrapped)
+	require.NoError(t, err)
+	require.Equal(t, storage.MasterKeyId(newKey), newId)
+
+	newKeyring, err := storage.NewKeyring(newKey)
+	require.NoError(t, err)
+
+	unwrapped, err = newKeyring.Unwrap(rewrapped, newId)
+	require.NoError(t, err)
+	require.Equal(t, dataKey, unwrapped)
+
+	_, err = newKeyring.Unwrap(wrapped, oldId)
+	require.ErrorIs(t, err, storage.ErrUnknownMasterKey)
+
+	_, err = storage.NewKeyring([]byte("short"))
+	require.Error(t, err)
+}
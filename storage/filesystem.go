@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilesDownloadPath is where the api serves files of the filesystem backend.
+const FilesDownloadPath = "/api/v1/files/"
+
+var (
+	ErrInvalidKey       = errors.New("invalid storage key")
+	ErrInvalidSignature = errors.New("invalid download url signature")
+	ErrUrlExpired       = errors.New("download url expired")
+)
+
+// FileStorage keeps files under a local directory for development without S3. Files
+// are downloaded through the api at FilesDownloadPath with urls signed by secret.
+type FileStorage struct {
+	dir     string
+	baseUrl string
+	secret  []byte
+}
+
+func NewFileStorage(dir, baseUrl string, secret []byte) *FileStorage {
+	return &FileStorage{
+		dir:     dir,
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		secret:  secret,
+	}
+}
+
+// path resolves key inside dir, rejecting keys that would escape it.
+func (s *FileStorage) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || strings.Contains(key, "..") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(cleaned)), nil
+}
+
+func (s *FileStorage) Put(ctx context.Context, key string, body io.Reader, size int64, metadata Metadata) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", key, err)
+	}
+
+	if err := writeAtomic(filePath, body); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+
+	return s.writeMetadata(key, filePath, metadata)
+}
+
+// writeAtomic writes to a temporary file first so readers never see a partial file.
+func writeAtomic(filePath string, body io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// metadataPath is the sidecar file holding the metadata of the file at filePath.
+func metadataPath(filePath string) string {
+	return filepath.Join(filepath.Dir(filePath), "."+filepath.Base(filePath)+".meta.json")
+}
+
+func (s *FileStorage) writeMetadata(key, filePath string, metadata Metadata) error {
+	content, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata of %s: %w", key, err)
+	}
+
+	if err := writeAtomic(metadataPath(filePath), bytes.NewReader(content)); err != nil {
+		return fmt.Errorf("failed to write metadata of %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// readMetadata returns the metadata of the file at filePath without tags, files
+// written before metadata was kept have none.
+func (s *FileStorage) readMetadata(key, filePath string) (Metadata, error) {
+	var metadata Metadata
+
+	content, err := os.ReadFile(metadataPath(filePath))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return metadata, nil
+		}
+		return metadata, fmt.Errorf("failed to read metadata of %s: %w", key, err)
+	}
+
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		return metadata, fmt.Errorf("failed to decode metadata of %s: %w", key, err)
+	}
+
+	metadata.Tags = nil
+	return metadata, nil
+}
+
+func (s *FileStorage) UpdateMetadata(ctx context.Context, key string, metadata Metadata) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(filePath); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+
+	return s.writeMetadata(key, filePath, metadata)
+}
+
+// Get opens the file under key, the returned reader is an *os.File and can seek.
+func (s *FileStorage) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, nil, fmt.Errorf("failed to open %s: %w", key, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+
+	metadata, err := s.readMetadata(key, filePath)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return file, fileInfo(key, info, metadata), nil
+}
+
+func (s *FileStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	body, _, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	file := body.(*os.File)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to seek %s: %w", key, err)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+func (s *FileStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+
+	metadata, err := s.readMetadata(key, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return fileInfo(key, info, metadata), nil
+}
+
+func (s *FileStorage) Delete(ctx context.Context, key string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{filePath, metadataPath(filePath)} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func fileInfo(key string, info fs.FileInfo, metadata Metadata) *ObjectInfo {
+	return &ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime(), ETag: etag(info.Size(), info.ModTime()), Metadata: metadata}
+}
+
+// PresignGet returns the api url of the file, signed so it can be downloaded without
+// an access token until it expires.
+func (s *FileStorage) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+
+	key = strings.TrimPrefix(key, "/")
+	expiresAt := time.Now().Add(expires).Unix()
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expiresAt, 10))
+	query.Set("signature", s.sign(key, expiresAt))
+
+	return s.baseUrl + FilesDownloadPath + key + "?" + query.Encode(), nil
+}
+
+// Verify checks the expires and signature query parameters of a url built by PresignGet for key.
+func (s *FileStorage) Verify(key string, query url.Values) error {
+	key = strings.TrimPrefix(key, "/")
+
+	expiresAt, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	expected, _ := hex.DecodeString(s.sign(key, expiresAt))
+	if !hmac.Equal(signature, expected) {
+		return ErrInvalidSignature
+	}
+
+	if time.Now().Unix() > expiresAt {
+		return ErrUrlExpired
+	}
+
+	return nil
+}
+
+func (s *FileStorage) sign(key string, expiresAt int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + strconv.FormatInt(expiresAt, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
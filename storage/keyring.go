@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/victor-devv/report-gen/config"
+	"github.com/victor-devv/report-gen/store"
+)
+
+var ErrUnknownMasterKey = errors.New("unknown master key")
+
+// Keyring holds the master keys that wrap the per user data keys. New data keys are
+// wrapped by the current key, previous keys are kept to unwrap data keys until they
+// are rotated.
+type Keyring struct {
+	currentId string
+	keys      map[string]cipher.AEAD
+}
+
+// NewKeyring builds a keyring from 32 byte AES-256 master keys.
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+	keyring := &Keyring{keys: make(map[string]cipher.AEAD)}
+
+	for i, key := range append([][]byte{current}, previous...) {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("master keys must be 32 bytes, got %d", len(key))
+		}
+
+		aead, err := newAead(key)
+		if err != nil {
+			return nil, err
+		}
+
+		id := MasterKeyId(key)
+		if i == 0 {
+			keyring.currentId = id
+		}
+		keyring.keys[id] = aead
+	}
+
+	return keyring, nil
+}
+
+// LoadKeyring builds the keyring from MASTER_KEY or MASTER_KEY_FILE and PREVIOUS_MASTER_KEYS,
+// all base64 encoded.
+func LoadKeyring(conf *config.Config) (*Keyring, error) {
+	encoded := conf.MasterKey
+	if encoded == "" && conf.MasterKeyFile != "" {
+		content, err := os.ReadFile(conf.MasterKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key file: %w", err)
+		}
+		encoded = strings.TrimSpace(string(content))
+	}
+
+	if encoded == "" {
+		return nil, errors.New("envelope encryption requires MASTER_KEY or MASTER_KEY_FILE")
+	}
+
+	current, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode master key: %w", err)
+	}
+
+	var previous [][]byte
+	for _, encoded := range conf.PreviousMasterKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode previous master key: %w", err)
+		}
+		previous = append(previous, key)
+	}
+
+	return NewKeyring(current, previous...)
+}
+
+// MasterKeyId identifies a master key without revealing it.
+func MasterKeyId(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (k *Keyring) CurrentId() string {
+	return k.currentId
+}
+
+// Wrap encrypts a data key with the current master key.
+func (k *Keyring) Wrap(dataKey []byte) ([]byte, string, error) {
+	aead := k.keys[k.currentId]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, dataKey, []byte(k.currentId)), k.currentId, nil
+}
+
+// Unwrap decrypts a data key wrapped by the master key masterKeyId.
+func (k *Keyring) Unwrap(wrapped []byte, masterKeyId string) ([]byte, error) {
+	aead, ok := k.keys[masterKeyId]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownMasterKey, masterKeyId)
+	}
+
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped data key is too short")
+	}
+
+	dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(masterKeyId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	return dataKey, nil
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gcm: %w", err)
+	}
+
+	return aead, nil
+}
+
+// RotateDataKeys rewraps every data key not wrapped by the current master key with it.
+// Files stay as they are, only the wrapped data keys change, so once it returns the
+// previous master keys can be dropped from the keyring.
+func RotateDataKeys(ctx context.Context, keyring *Keyring, dataKeyStore *store.DataKeyStore) (int, error) {
+	rotated := 0
+	for {
+		dataKeys, err := dataKeyStore.NotWrappedWith(ctx, keyring.CurrentId(), 100)
+		if err != nil {
+			return rotated, err
+		}
+
+		if len(dataKeys) == 0 {
+			return rotated, nil
+		}
+
+		for _, dataKey := range dataKeys {
+			plain, err := keyring.Unwrap(dataKey.WrappedKey, dataKey.MasterKeyId)
+			if err != nil {
+				return rotated, fmt.Errorf("failed to rotate data key of user %s: %w", dataKey.UserId, err)
+			}
+
+			wrapped, masterKeyId, err := keyring.Wrap(plain)
+			if err != nil {
+				return rotated, err
+			}
+
+			if _, err := dataKeyStore.Rewrap(ctx, &dataKey, wrapped, masterKeyId); err != nil {
+				// rotated concurrently, the next page no longer includes it
+				if errors.Is(err, sql.ErrNoRows) {
+					continue
+				}
+				return rotated, err
+			}
+			rotated++
+		}
+	}
+}
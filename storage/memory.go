@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data         []byte
+	lastModified time.Time
+	metadata     Metadata
+}
+
+func (o memoryObject) info(key string) *ObjectInfo {
+	metadata := o.metadata
+	metadata.Tags = nil
+	return &ObjectInfo{Key: key, Size: int64(len(o.data)), LastModified: o.lastModified, ETag: etag(int64(len(o.data)), o.lastModified), Metadata: metadata}
+}
+
+// MemoryStorage keeps files in memory, for tests and throwaway local runs. Its urls
+// are not downloadable, they only identify the file and when the url expires.
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		objects: make(map[string]memoryObject),
+	}
+}
+
+func (s *MemoryStorage) Put(ctx context.Context, key string, body io.Reader, size int64, metadata Metadata) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", key, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = memoryObject{data: data, lastModified: time.Now(), metadata: metadata}
+
+	return nil
+}
+
+func (s *MemoryStorage) UpdateMetadata(ctx context.Context, key string, metadata Metadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object, ok := s.objects[key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	object.metadata = metadata
+	s.objects[key] = object
+
+	return nil
+}
+
+// Tags returns the tags of the file under key.
+func (s *MemoryStorage) Tags(key string) map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.objects[key].metadata.Tags
+}
+
+func (s *MemoryStorage) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	object, ok := s.objects[key]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	return io.NopCloser(bytes.NewReader(object.data)), object.info(key), nil
+}
+
+func (s *MemoryStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	object, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	size := int64(len(object.data))
+	if offset < 0 || length < 0 || offset > size {
+		return nil, fmt.Errorf("invalid range %d+%d of %s", offset, length, key)
+	}
+
+	return io.NopCloser(bytes.NewReader(object.data[offset:min(offset+length, size)])), nil
+}
+
+func (s *MemoryStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	object, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	return object.info(key), nil
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+
+	return nil
+}
+
+// PresignGet is not supported, files in memory can't be downloaded from a url of their
+// own, so downloads go through the api.
+func (s *MemoryStorage) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "", ErrPresignUnsupported
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// RangeReader reads a stored file through ranged gets so it can seek, e.g. for
+// http.ServeContent to answer Range requests without downloading the whole file.
+type RangeReader struct {
+	ctx     context.Context
+	storage Storage
+	info    *ObjectInfo
+	offset  int64
+	body    io.ReadCloser
+}
+
+func NewRangeReader(ctx context.Context, storage Storage, info *ObjectInfo) *RangeReader {
+	return &RangeReader{
+		ctx:     ctx,
+		storage: storage,
+		info:    info,
+	}
+}
+
+func (r *RangeReader) Read(p []byte) (int, error) {
+	if r.offset >= r.info.Size {
+		return 0, io.EOF
+	}
+
+	// the body is opened lazily, ServeContent seeks a few times before reading
+	if r.body == nil {
+		body, err := r.storage.GetRange(r.ctx, r.info.Key, r.offset, r.info.Size-r.offset)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *RangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.info.Size
+	}
+
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the file")
+	}
+
+	if offset != r.offset {
+		if err := r.Close(); err != nil {
+			return 0, err
+		}
+		r.offset = offset
+	}
+
+	return offset, nil
+}
+
+func (r *RangeReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,71 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/storage"
+	"github.com/victor-devv/report-gen/store"
+)
+
+func TestRangeReader(t *testing.T) {
+	ctx := context.Background()
+	keyring, err := storage.NewKeyring(masterKey(t))
+	require.NoError(t, err)
+
+	backends := map[string]storage.Storage{
+		"filesystem": storage.NewFileStorage(t.TempDir(), "http://localhost:4000", []byte("secret")),
+		"memory":     storage.NewMemoryStorage(),
+		"encrypted":  storage.NewEncryptedStorage(storage.NewMemoryStorage(), keyring, &memoryDataKeys{keys: make(map[uuid.UUID]*store.DataKey)}, false),
+	}
+
+	content := make([]byte, 3*64*1024+100)
+	_, err = rand.Read(content)
+	require.NoError(t, err)
+
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			key := storage.ReportKey(uuid.New(), uuid.New())
+			require.NoError(t, backend.Put(ctx, key, bytes.NewReader(content), int64(len(content)), storage.Metadata{}))
+
+			info, err := backend.Stat(ctx, key)
+			require.NoError(t, err)
+			require.NotEmpty(t, info.ETag)
+
+			// ranges within a chunk, across chunks and up to the end
+			for _, r := range [][2]int{{0, 10}, {10, 64 * 1024}, {64*1024 - 5, 64*1024 + 10}, {len(content) - 50, len(content)}} {
+				body, err := backend.GetRange(ctx, key, int64(r[0]), int64(r[1]-r[0]))
+				require.NoError(t, err)
+				got, err := io.ReadAll(body)
+				require.NoError(t, err)
+				require.NoError(t, body.Close())
+				require.Equal(t, content[r[0]:r[1]], got)
+			}
+
+			reader := storage.NewRangeReader(ctx, backend, info)
+			defer reader.Close()
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Range", "bytes=70000-70009")
+			recorder := httptest.NewRecorder()
+			http.ServeContent(recorder, request, "report.csv.gz", info.LastModified, reader)
+
+			require.Equal(t, http.StatusPartialContent, recorder.Code)
+			require.Equal(t, content[70000:70010], recorder.Body.Bytes())
+
+			// seeking back reads from the start again
+			_, err = reader.Seek(0, io.SeekStart)
+			require.NoError(t, err)
+			got, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			require.Equal(t, content, got)
+		})
+	}
+}
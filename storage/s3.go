@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3Encryption selects the server side encryption S3 applies to uploaded files.
+type S3Encryption struct {
+	// Mode is one of EncryptionSseS3, EncryptionSseKms or EncryptionSseC, empty leaves it to the bucket
+	Mode string
+	// KmsKeyId is the kms key for EncryptionSseKms, empty uses the aws managed key
+	KmsKeyId string
+	// CustomerKey is the 32 byte key for EncryptionSseC
+	CustomerKey []byte
+}
+
+type S3Storage struct {
+	client        *s3.Client
+	presignClient *s3.PresignClient
+	bucket        string
+	encryption    S3Encryption
+}
+
+func NewS3Storage(client *s3.Client, bucket string, encryption S3Encryption) *S3Storage {
+	return &S3Storage{
+		client:        client,
+		presignClient: s3.NewPresignClient(client),
+		bucket:        bucket,
+		encryption:    encryption,
+	}
+}
+
+// customerKey returns the sse-c algorithm, key and key md5 headers, or nils without sse-c.
+func (s *S3Storage) customerKey() (*string, *string, *string) {
+	if s.encryption.Mode != EncryptionSseC {
+		return nil, nil, nil
+	}
+
+	sum := md5.Sum(s.encryption.CustomerKey)
+	return aws.String("AES256"),
+		aws.String(base64.StdEncoding.EncodeToString(s.encryption.CustomerKey)),
+		aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// serverSideEncryption returns the sse-s3 or sse-kms settings of uploads.
+func (s *S3Storage) serverSideEncryption() (types.ServerSideEncryption, *string) {
+	switch s.encryption.Mode {
+	case EncryptionSseS3:
+		return types.ServerSideEncryptionAes256, nil
+	case EncryptionSseKms:
+		if s.encryption.KmsKeyId != "" {
+			return types.ServerSideEncryptionAwsKms, aws.String(s.encryption.KmsKeyId)
+		}
+		return types.ServerSideEncryptionAwsKms, nil
+	}
+	return "", nil
+}
+
+// tagging encodes tags as the url query S3 expects.
+func tagging(tags map[string]string) *string {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	values := url.Values{}
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+	return aws.String(values.Encode())
+}
+
+// optional turns empty metadata values into unset headers.
+func optional(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return aws.String(value)
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, body io.Reader, size int64, metadata Metadata) error {
+	input := &s3.PutObjectInput{
+		Bucket:             aws.String(s.bucket),
+		Key:                aws.String(key),
+		Body:               body,
+		ContentLength:      aws.Int64(size),
+		ContentType:        optional(metadata.ContentType),
+		ContentEncoding:    optional(metadata.ContentEncoding),
+		ContentDisposition: optional(metadata.ContentDisposition),
+		Tagging:            tagging(metadata.Tags),
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = s.serverSideEncryption()
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.customerKey()
+
+	if _, err := s.client.PutObject(ctx, input); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// UpdateMetadata copies the object onto itself, the only way S3 changes the metadata of an object.
+func (s *S3Storage) UpdateMetadata(ctx context.Context, key string, metadata Metadata) error {
+	input := &s3.CopyObjectInput{
+		Bucket:             aws.String(s.bucket),
+		Key:                aws.String(key),
+		CopySource:         aws.String(s.bucket + "/" + strings.ReplaceAll(url.PathEscape(key), "%2F", "/")),
+		MetadataDirective:  types.MetadataDirectiveReplace,
+		TaggingDirective:   types.TaggingDirectiveReplace,
+		ContentType:        optional(metadata.ContentType),
+		ContentEncoding:    optional(metadata.ContentEncoding),
+		ContentDisposition: optional(metadata.ContentDisposition),
+		Tagging:            tagging(metadata.Tags),
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = s.serverSideEncryption()
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.customerKey()
+	input.CopySourceSSECustomerAlgorithm, input.CopySourceSSECustomerKey, input.CopySourceSSECustomerKeyMD5 = s.customerKey()
+
+	if _, err := s.client.CopyObject(ctx, input); err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return fmt.Errorf("failed to update metadata of %s: %w", key, err)
+	}
+
+	return nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.customerKey()
+
+	output, err := s.client.GetObject(ctx, input)
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, nil, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+
+	return output.Body, &ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(output.ContentLength),
+		LastModified: aws.ToTime(output.LastModified),
+		ETag:         aws.ToString(output.ETag),
+		Metadata: Metadata{
+			ContentType:        aws.ToString(output.ContentType),
+			ContentEncoding:    aws.ToString(output.ContentEncoding),
+			ContentDisposition: aws.ToString(output.ContentDisposition),
+		},
+	}, nil
+}
+
+func (s *S3Storage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.customerKey()
+
+	output, err := s.client.GetObject(ctx, input)
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+
+	return output.Body, nil
+}
+
+func (s *S3Storage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.customerKey()
+
+	output, err := s.client.HeadObject(ctx, input)
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+
+	return &ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(output.ContentLength),
+		LastModified: aws.ToTime(output.LastModified),
+		ETag:         aws.ToString(output.ETag),
+		Metadata: Metadata{
+			ContentType:        aws.ToString(output.ContentType),
+			ContentEncoding:    aws.ToString(output.ContentEncoding),
+			ContentDisposition: aws.ToString(output.ContentDisposition),
+		},
+	}, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// PresignGet is not supported with sse-c, a presigned url would need the customer key
+// sent by the client, so those files are downloaded through the api.
+func (s *S3Storage) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if s.encryption.Mode == EncryptionSseC {
+		return "", ErrPresignUnsupported
+	}
+
+	signed, err := s.presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, func(options *s3.PresignOptions) {
+		options.Expires = expires
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to presign %s: %w", key, err)
+	}
+
+	return signed.URL, nil
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/victor-devv/report-gen/config"
+)
+
+const (
+	BackendS3         = "s3"
+	BackendFilesystem = "filesystem"
+	BackendMemory     = "memory"
+)
+
+// STORAGE_ENCRYPTION modes, files are stored as is when it is empty.
+const (
+	// EncryptionEnvelope encrypts files in the api with per user data keys, see EncryptedStorage
+	EncryptionEnvelope = "envelope"
+	EncryptionSseS3    = "sse-s3"
+	EncryptionSseKms   = "sse-kms"
+	EncryptionSseC     = "sse-c"
+)
+
+var ErrNotFound = errors.New("file not found")
+
+// Metadata is sent along with a file when it is downloaded, Tags are for lifecycle rules
+// and cost allocation and never reach clients.
+type Metadata struct {
+	ContentType        string
+	ContentEncoding    string
+	ContentDisposition string
+	Tags               map[string]string
+}
+
+// ObjectInfo describes a stored file.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	// ETag changes whenever the file does, it is quoted for use as an http header
+	ETag string
+	// Metadata of the file, without tags
+	Metadata Metadata
+}
+
+// etag is the ETag of backends that don't keep one, derived from the size and modification time.
+func etag(size int64, lastModified time.Time) string {
+	return fmt.Sprintf(`"%x-%x"`, lastModified.UnixNano(), size)
+}
+
+// Storage holds the generated report files.
+type Storage interface {
+	// Put streams size bytes read from body to key, replacing any existing file.
+	Put(ctx context.Context, key string, body io.Reader, size int64, metadata Metadata) error
+	// Get opens the file under key, the caller must close it. Missing files are ErrNotFound.
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// GetRange opens length bytes of the file under key starting at offset, the caller must close it.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Stat describes the file under key without reading it. Missing files are ErrNotFound.
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// UpdateMetadata replaces the metadata and tags of the file under key without changing its content.
+	UpdateMetadata(ctx context.Context, key string, metadata Metadata) error
+	// Delete removes the file under key, deleting a missing file is not an error.
+	Delete(ctx context.Context, key string) error
+	// PresignGet returns a url the file under key can be downloaded from for expires.
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// ReportKey is the key of the csv built for a report.
+func ReportKey(userId, reportId uuid.UUID) string {
+	return "users/" + userId.String() + "/reports/" + reportId.String() + ".csv.gz"
+}
+
+// QuarantineKey is the key of the rows of a report that failed validation.
+func QuarantineKey(userId, reportId uuid.UUID) string {
+	return "users/" + userId.String() + "/reports/" + reportId.String() + ".quarantine.csv.gz"
+}
+
+// UserPrefix is the prefix of every key owned by a user.
+func UserPrefix(userId uuid.UUID) string {
+	return "users/" + userId.String() + "/"
+}
+
+// KeyOwner returns the user owning key.
+func KeyOwner(key string) (uuid.UUID, error) {
+	parts := strings.SplitN(strings.TrimPrefix(key, "/"), "/", 3)
+	if len(parts) < 3 || parts[0] != "users" {
+		return uuid.Nil, fmt.Errorf("%w: %q has no owner", ErrInvalidKey, key)
+	}
+
+	userId, err := uuid.Parse(parts[1])
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: %q has no owner", ErrInvalidKey, key)
+	}
+
+	return userId, nil
+}
+
+// New builds the storage selected by STORAGE_BACKEND and STORAGE_ENCRYPTION. s3Client
+// is only required for the s3 backend and dataKeys only for envelope encryption.
+func New(conf *config.Config, s3Client *s3.Client, dataKeys DataKeys) (Storage, error) {
+	var storage Storage
+	switch conf.StorageBackend {
+	case BackendS3:
+		if s3Client == nil {
+			return nil, errors.New("the s3 storage backend requires an s3 client")
+		}
+
+		encryption, err := s3Encryption(conf)
+		if err != nil {
+			return nil, err
+		}
+		storage = NewS3Storage(s3Client, conf.S3Bucket, encryption)
+	case BackendFilesystem:
+		if conf.StorageUrlSecret == "" {
+			return nil, errors.New("the filesystem storage backend requires STORAGE_URL_SECRET to sign download urls")
+		}
+		storage = NewFileStorage(conf.StorageDir, conf.PublicUrl(), []byte(conf.StorageUrlSecret))
+	case BackendMemory:
+		storage = NewMemoryStorage()
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", conf.StorageBackend)
+	}
+
+	switch conf.StorageEncryption {
+	case "":
+		return storage, nil
+	case EncryptionEnvelope:
+		if dataKeys == nil {
+			return nil, errors.New("envelope encryption requires a data key store")
+		}
+
+		keyring, err := LoadKeyring(conf)
+		if err != nil {
+			return nil, err
+		}
+		return NewEncryptedStorage(storage, keyring, dataKeys, conf.StorageReadUnencrypted), nil
+	case EncryptionSseS3, EncryptionSseKms, EncryptionSseC:
+		if conf.StorageBackend != BackendS3 {
+			return nil, fmt.Errorf("%s encryption requires the s3 storage backend", conf.StorageEncryption)
+		}
+		return storage, nil
+	}
+
+	return nil, fmt.Errorf("unknown storage encryption %q", conf.StorageEncryption)
+}
+
+func s3Encryption(conf *config.Config) (S3Encryption, error) {
+	encryption := S3Encryption{KmsKeyId: conf.S3KmsKeyId}
+
+	switch conf.StorageEncryption {
+	case EncryptionSseS3, EncryptionSseKms:
+		encryption.Mode = conf.StorageEncryption
+	case EncryptionSseC:
+		key, err := base64.StdEncoding.DecodeString(conf.S3SseCustomerKey)
+		if err != nil || len(key) != 32 {
+			return S3Encryption{}, errors.New("sse-c encryption requires S3_SSE_CUSTOMER_KEY, a base64 encoded 32 byte key")
+		}
+		encryption.Mode = EncryptionSseC
+		encryption.CustomerKey = key
+	}
+
+	return encryption, nil
+}
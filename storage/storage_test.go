@@ -0,0 +1,108 @@
+package storage_test
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/storage"
+)
+
+func TestReportKey(t *testing.T) {
+	userId := uuid.MustParse("6f1d0a3e-8f0e-4b8a-9d57-0c1e6b1f4a01")
+	reportId := uuid.MustParse("b2c7d7b4-3c52-4d7e-8a43-5f4f3e0f9a12")
+
+	require.Equal(t, "users/6f1d0a3e-8f0e-4b8a-9d57-0c1e6b1f4a01/reports/b2c7d7b4-3c52-4d7e-8a43-5f4f3e0f9a12.csv.gz", storage.ReportKey(userId, reportId))
+	require.Equal(t, "users/6f1d0a3e-8f0e-4b8a-9d57-0c1e6b1f4a01/reports/b2c7d7b4-3c52-4d7e-8a43-5f4f3e0f9a12.quarantine.csv.gz", storage.QuarantineKey(userId, reportId))
+	require.True(t, strings.HasPrefix(storage.ReportKey(userId, reportId), storage.UserPrefix(userId)))
+}
+
+func TestStorage(t *testing.T) {
+	backends := map[string]storage.Storage{
+		"filesystem": storage.NewFileStorage(t.TempDir(), "http://localhost:4000", []byte("secret")),
+		"memory":     storage.NewMemoryStorage(),
+	}
+
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			key := "users/1/reports/2.csv.gz"
+			body := "id,name\n1,link\n"
+
+			_, err := backend.Stat(ctx, key)
+			require.ErrorIs(t, err, storage.ErrNotFound)
+
+			metadata := storage.Metadata{ContentType: "text/csv", ContentEncoding: "gzip", Tags: map[string]string{"report_type": "monsters"}}
+			require.NoError(t, backend.Put(ctx, key, strings.NewReader(body), int64(len(body)), metadata))
+
+			info, err := backend.Stat(ctx, key)
+			require.NoError(t, err)
+			require.Equal(t, int64(len(body)), info.Size)
+			require.Equal(t, storage.Metadata{ContentType: "text/csv", ContentEncoding: "gzip"}, info.Metadata)
+			if memory, ok := backend.(*storage.MemoryStorage); ok {
+				require.Equal(t, map[string]string{"report_type": "monsters"}, memory.Tags(key))
+			}
+
+			// metadata changes leave the content alone
+			require.NoError(t, backend.UpdateMetadata(ctx, key, storage.Metadata{ContentType: "text/plain"}))
+			info, err = backend.Stat(ctx, key)
+			require.NoError(t, err)
+			require.Equal(t, int64(len(body)), info.Size)
+			require.Equal(t, storage.Metadata{ContentType: "text/plain"}, info.Metadata)
+
+			// files in memory have no url of their own to redirect downloads to
+			if _, ok := backend.(*storage.MemoryStorage); ok {
+				_, err := backend.PresignGet(ctx, key, time.Minute)
+				require.ErrorIs(t, err, storage.ErrPresignUnsupported)
+			}
+
+			reader, info, err := backend.Get(ctx, key)
+			require.NoError(t, err)
+			content, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			require.NoError(t, reader.Close())
+			require.Equal(t, body, string(content))
+			require.Equal(t, int64(len(body)), info.Size)
+
+			require.NoError(t, backend.Delete(ctx, key))
+			require.NoError(t, backend.Delete(ctx, key))
+
+			_, _, err = backend.Get(ctx, key)
+			require.ErrorIs(t, err, storage.ErrNotFound)
+			require.ErrorIs(t, backend.UpdateMetadata(ctx, key, metadata), storage.ErrNotFound)
+		})
+	}
+}
+
+func TestFileStorageSignedUrls(t *testing.T) {
+	ctx := context.Background()
+	fileStorage := storage.NewFileStorage(t.TempDir(), "http://localhost:4000/", []byte("secret"))
+
+	signed, err := fileStorage.PresignGet(ctx, "users/1/reports/2.csv.gz", time.Minute)
+	require.NoError(t, err)
+
+	parsed, err := url.Parse(signed)
+	require.NoError(t, err)
+	require.Equal(t, "/api/v1/files/users/1/reports/2.csv.gz", parsed.Path)
+	require.NoError(t, fileStorage.Verify("users/1/reports/2.csv.gz", parsed.Query()))
+
+	// the signature covers the key and the expiry
+	require.ErrorIs(t, fileStorage.Verify("users/3/reports/2.csv.gz", parsed.Query()), storage.ErrInvalidSignature)
+	tampered := parsed.Query()
+	tampered.Set("expires", "9999999999")
+	require.ErrorIs(t, fileStorage.Verify("users/1/reports/2.csv.gz", tampered), storage.ErrInvalidSignature)
+
+	expired, err := fileStorage.PresignGet(ctx, "users/1/reports/2.csv.gz", -time.Minute)
+	require.NoError(t, err)
+	parsed, err = url.Parse(expired)
+	require.NoError(t, err)
+	require.ErrorIs(t, fileStorage.Verify("users/1/reports/2.csv.gz", parsed.Query()), storage.ErrUrlExpired)
+
+	_, err = fileStorage.PresignGet(ctx, "users/1/../../etc/passwd", time.Minute)
+	require.ErrorIs(t, err, storage.ErrInvalidKey)
+}
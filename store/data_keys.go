@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+// DataKeyStore holds the per user keys report files are encrypted with. Keys are only
+// stored wrapped, i.e. encrypted, by a master key that never reaches the database.
+type DataKeyStore struct {
+	db *sqlx.DB
+}
+
+func NewDataKeyStore(db *sql.DB) *DataKeyStore {
+	return &DataKeyStore{
+		db: sqlx.NewDb(db, "postgres"),
+	}
+}
+
+type DataKey struct {
+	UserId      uuid.UUID  `db:"user_id" json:"user_id"`
+	WrappedKey  []byte     `db:"wrapped_key" json:"-"`
+	MasterKeyId string     `db:"master_key_id" json:"master_key_id"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	RotatedAt   *time.Time `db:"rotated_at" json:"rotated_at"`
+}
+
+// Create stores the data key of a user unless one exists already, in which case the
+// existing key is returned so concurrent first writes agree on a single key.
+func (s *DataKeyStore) Create(ctx context.Context, userId uuid.UUID, wrappedKey []byte, masterKeyId string) (*DataKey, error) {
+	const dml = `INSERT INTO user_data_keys (user_id, wrapped_key, master_key_id) VALUES ($1, $2, $3)
+								ON CONFLICT (user_id) DO NOTHING RETURNING *`
+	var dataKey DataKey
+
+	if err := s.db.GetContext(ctx, &dataKey, dml, userId, wrappedKey, masterKeyId); err != nil {
+		if err == sql.ErrNoRows {
+			return s.ByUserId(ctx, userId)
+		}
+		return nil, fmt.Errorf("failed to create data key for user %s: %w", userId, err)
+	}
+
+	return &dataKey, nil
+}
+
+func (s *DataKeyStore) ByUserId(ctx context.Context, userId uuid.UUID) (*DataKey, error) {
+	const query = `SELECT * FROM user_data_keys WHERE user_id = $1`
+	var dataKey DataKey
+
+	if err := s.db.GetContext(ctx, &dataKey, query, userId); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to fetch data key for user %s: %w", userId, err)
+	}
+
+	return &dataKey, nil
+}
+
+// NotWrappedWith returns up to limit data keys wrapped by a master key other than masterKeyId.
+func (s *DataKeyStore) NotWrappedWith(ctx context.Context, masterKeyId string, limit int) ([]DataKey, error) {
+	const query = `SELECT * FROM user_data_keys WHERE master_key_id <> $1 ORDER BY user_id LIMIT $2`
+	dataKeys := []DataKey{}
+
+	if err := s.db.SelectContext(ctx, &dataKeys, query, masterKeyId, limit); err != nil {
+		return nil, fmt.Errorf("failed to list data keys: %w", err)
+	}
+
+	return dataKeys, nil
+}
+
+// Rewrap replaces the wrapped key of a user, provided it is still wrapped by the master
+// key it was read with. sql.ErrNoRows means it was rewrapped in the meantime.
+func (s *DataKeyStore) Rewrap(ctx context.Context, dataKey *DataKey, wrappedKey []byte, masterKeyId string) (*DataKey, error) {
+	const dml = `UPDATE user_data_keys
+							SET wrapped_key = $1, master_key_id = $2, rotated_at = now()
+							WHERE user_id = $3 AND master_key_id = $4
+							RETURNING *`
+	var rewrapped DataKey
+
+	if err := s.db.GetContext(ctx, &rewrapped, dml, wrappedKey, masterKeyId, dataKey.UserId, dataKey.MasterKeyId); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to rewrap data key for user %s: %w", dataKey.UserId, err)
+	}
+
+	return &rewrapped, nil
+}
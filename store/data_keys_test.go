@@ -0,0 +1,55 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/fixtures"
+	"github.com/victor-devv/report-gen/store"
+)
+
+func TestDataKeyStore(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	userStore := store.NewUserStore(env.Db)
+	dataKeyStore := store.NewDataKeyStore(env.Db)
+
+	user, err := userStore.Create(ctx, "test@testemail.com", "testPassword")
+	require.NoError(t, err)
+
+	_, err = dataKeyStore.ByUserId(ctx, user.Id)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	dataKey, err := dataKeyStore.Create(ctx, user.Id, []byte("wrapped-a"), "old")
+	require.NoError(t, err)
+	require.Equal(t, []byte("wrapped-a"), dataKey.WrappedKey)
+
+	// a second key for the same user loses to the first
+	dataKey, err = dataKeyStore.Create(ctx, user.Id, []byte("wrapped-b"), "old")
+	require.NoError(t, err)
+	require.Equal(t, []byte("wrapped-a"), dataKey.WrappedKey)
+
+	stale, err := dataKeyStore.NotWrappedWith(ctx, "new", 10)
+	require.NoError(t, err)
+	require.Len(t, stale, 1)
+
+	rewrapped, err := dataKeyStore.Rewrap(ctx, &stale[0], []byte("wrapped-c"), "new")
+	require.NoError(t, err)
+	require.Equal(t, "new", rewrapped.MasterKeyId)
+	require.NotNil(t, rewrapped.RotatedAt)
+
+	// rewrapping from a stale read does nothing
+	_, err = dataKeyStore.Rewrap(ctx, &stale[0], []byte("wrapped-d"), "new")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	stale, err = dataKeyStore.NotWrappedWith(ctx, "new", 10)
+	require.NoError(t, err)
+	require.Empty(t, stale)
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+type DataSourceStore struct {
+	db *sqlx.DB
+}
+
+func NewDataSourceStore(db *sql.DB) *DataSourceStore {
+	return &DataSourceStore{
+		db: sqlx.NewDb(db, "postgres"),
+	}
+}
+
+// FieldMapping turns the value found at Path inside a record into a report column.
+type FieldMapping struct {
+	Column string `json:"column"`
+	Path   string `json:"path"`
+}
+
+// Validation rules are checked per record before a report is written,
+// failing records are quarantined instead of ending up in the report.
+type Validation struct {
+	Required []string `json:"required,omitempty"`
+	Unique   []string `json:"unique,omitempty"`
+	Urls     []string `json:"urls,omitempty"`
+}
+
+// Assertions are checked against the validated rows and fail the whole build.
+type Assertions struct {
+	MinRows      int      `json:"min_rows,omitempty"`
+	NoDuplicates []string `json:"no_duplicates,omitempty"`
+}
+
+// DataSource describes an http json api that can back a report type named Name.
+type DataSource struct {
+	Id              uuid.UUID               `db:"id" json:"id"`
+	Name            string                  `db:"name" json:"name"`
+	BaseUrl         string                  `db:"base_url" json:"base_url"`
+	Path            string                  `db:"path" json:"path"`
+	QueryParams     Json[map[string]string] `db:"query_params" json:"query_params"`
+	AuthHeaderName  *string                 `db:"auth_header_name" json:"auth_header_name"`
+	AuthHeaderValue *string                 `db:"auth_header_value" json:"-"`
+	RecordsPath     string                  `db:"records_path" json:"records_path"`
+	FieldMapping    Json[[]FieldMapping]    `db:"field_mapping" json:"field_mapping"`
+	Validation      Json[Validation]        `db:"validation" json:"validation"`
+	Assertions      Json[Assertions]        `db:"assertions" json:"assertions"`
+	TimeoutSeconds  *int                    `db:"timeout_seconds" json:"timeout_seconds"`
+	CreatedAt       time.Time               `db:"created_at" json:"created_at"`
+}
+
+func (s *DataSourceStore) Create(ctx context.Context, dataSource *DataSource) (*DataSource, error) {
+	const dml = `INSERT INTO data_sources (name, base_url, path, query_params, auth_header_name, auth_header_value, records_path, field_mapping, validation, assertions, timeout_seconds)
+							VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING *`
+	var created DataSource
+
+	if err := s.db.GetContext(ctx, &created, dml,
+		dataSource.Name,
+		dataSource.BaseUrl,
+		dataSource.Path,
+		dataSource.QueryParams,
+		dataSource.AuthHeaderName,
+		dataSource.AuthHeaderValue,
+		dataSource.RecordsPath,
+		dataSource.FieldMapping,
+		dataSource.Validation,
+		dataSource.Assertions,
+		dataSource.TimeoutSeconds,
+	); err != nil {
+		return nil, fmt.Errorf("failed to create data source: %w", err)
+	}
+
+	return &created, nil
+}
+
+func (s *DataSourceStore) ByName(ctx context.Context, name string) (*DataSource, error) {
+	const query = `SELECT * FROM data_sources WHERE name = $1`
+	var dataSource DataSource
+
+	if err := s.db.GetContext(ctx, &dataSource, query, name); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to fetch data source %s: %w", name, err)
+	}
+
+	return &dataSource, nil
+}
+
+func (s *DataSourceStore) List(ctx context.Context) ([]DataSource, error) {
+	const query = `SELECT * FROM data_sources ORDER BY name`
+	dataSources := []DataSource{}
+
+	if err := s.db.SelectContext(ctx, &dataSources, query); err != nil {
+		return nil, fmt.Errorf("failed to list data sources: %w", err)
+	}
+
+	return dataSources, nil
+}
+
+func (s *DataSourceStore) Delete(ctx context.Context, id uuid.UUID) (sql.Result, error) {
+	const query = `DELETE FROM data_sources WHERE id = $1`
+
+	result, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return result, fmt.Errorf("failed to delete data source %s: %w", id, err)
+	}
+
+	return result, nil
+}
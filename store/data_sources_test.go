@@ -0,0 +1,57 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/fixtures"
+	"github.com/victor-devv/report-gen/store"
+)
+
+func TestDataSourceStore(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	dataSourceStore := store.NewDataSourceStore(env.Db)
+
+	authHeaderName := "X-Api-Key"
+	authHeaderValue := "secret"
+	dataSource, err := dataSourceStore.Create(ctx, &store.DataSource{
+		Name:            "creatures",
+		BaseUrl:         "https://example.com/api",
+		Path:            "/creatures",
+		QueryParams:     store.NewJson(map[string]string{"game": "totk"}),
+		AuthHeaderName:  &authHeaderName,
+		AuthHeaderValue: &authHeaderValue,
+		RecordsPath:     "$.data",
+		FieldMapping:    store.NewJson([]store.FieldMapping{{Column: "name", Path: "name"}}),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "creatures", dataSource.Name)
+	require.Equal(t, map[string]string{"game": "totk"}, dataSource.QueryParams.Val)
+	require.Equal(t, []store.FieldMapping{{Column: "name", Path: "name"}}, dataSource.FieldMapping.Val)
+
+	dataSource2, err := dataSourceStore.ByName(ctx, "creatures")
+	require.NoError(t, err)
+	require.Equal(t, dataSource.Id, dataSource2.Id)
+	require.Equal(t, &authHeaderValue, dataSource2.AuthHeaderValue)
+
+	dataSources, err := dataSourceStore.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, dataSources, 1)
+
+	result, err := dataSourceStore.Delete(ctx, dataSource.Id)
+	require.NoError(t, err)
+	rowsAffected, err := result.RowsAffected()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), rowsAffected)
+
+	_, err = dataSourceStore.ByName(ctx, "creatures")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
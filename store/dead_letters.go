@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+type DeadLetterStore struct {
+	db *sqlx.DB
+}
+
+func NewDeadLetterStore(db *sql.DB) *DeadLetterStore {
+	return &DeadLetterStore{
+		db: sqlx.NewDb(db, "postgres"),
+	}
+}
+
+// DeadLetter is a queue message the worker gave up on, kept for inspection and redrive.
+type DeadLetter struct {
+	Id           uuid.UUID `db:"id" json:"id"`
+	MessageId    string    `db:"message_id" json:"message_id"`
+	Body         string    `db:"body" json:"body"`
+	ReceiveCount int       `db:"receive_count" json:"receive_count"`
+	LastError    string    `db:"last_error" json:"last_error"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+}
+
+func (s *DeadLetterStore) Create(ctx context.Context, messageId, body string, receiveCount int, lastError string) (*DeadLetter, error) {
+	const dml = `INSERT INTO dead_letters (message_id, body, receive_count, last_error) VALUES ($1, $2, $3, $4) RETURNING *`
+	var deadLetter DeadLetter
+
+	if err := s.db.GetContext(ctx, &deadLetter, dml, messageId, body, receiveCount, lastError); err != nil {
+		return nil, fmt.Errorf("failed to create dead letter for message %s: %w", messageId, err)
+	}
+
+	return &deadLetter, nil
+}
+
+func (s *DeadLetterStore) ById(ctx context.Context, id uuid.UUID) (*DeadLetter, error) {
+	const query = `SELECT * FROM dead_letters WHERE id = $1`
+	var deadLetter DeadLetter
+
+	if err := s.db.GetContext(ctx, &deadLetter, query, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to fetch dead letter %s: %w", id, err)
+	}
+
+	return &deadLetter, nil
+}
+
+func (s *DeadLetterStore) List(ctx context.Context) ([]DeadLetter, error) {
+	const query = `SELECT * FROM dead_letters ORDER BY created_at`
+	deadLetters := []DeadLetter{}
+
+	if err := s.db.SelectContext(ctx, &deadLetters, query); err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+
+	return deadLetters, nil
+}
+
+func (s *DeadLetterStore) Delete(ctx context.Context, id uuid.UUID) (sql.Result, error) {
+	const query = `DELETE FROM dead_letters WHERE id = $1`
+
+	result, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return result, fmt.Errorf("failed to delete dead letter %s: %w", id, err)
+	}
+
+	return result, nil
+}
+
+func (s *DeadLetterStore) Purge(ctx context.Context) (sql.Result, error) {
+	const query = `DELETE FROM dead_letters`
+
+	result, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return result, fmt.Errorf("failed to purge dead letters: %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,55 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/fixtures"
+	"github.com/victor-devv/report-gen/store"
+)
+
+func TestDeadLetterStore(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	deadLetterStore := store.NewDeadLetterStore(env.Db)
+
+	deadLetter, err := deadLetterStore.Create(ctx, "message-1", "{not json", 1, "invalid message")
+	require.NoError(t, err)
+	require.Equal(t, "message-1", deadLetter.MessageId)
+	require.Equal(t, "{not json", deadLetter.Body)
+	require.Equal(t, 1, deadLetter.ReceiveCount)
+	require.Equal(t, "invalid message", deadLetter.LastError)
+
+	deadLetter2, err := deadLetterStore.ById(ctx, deadLetter.Id)
+	require.NoError(t, err)
+	require.Equal(t, deadLetter.Id, deadLetter2.Id)
+
+	_, err = deadLetterStore.Create(ctx, "message-2", "{}", 5, "failed to build report")
+	require.NoError(t, err)
+
+	deadLetters, err := deadLetterStore.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 2)
+
+	result, err := deadLetterStore.Delete(ctx, deadLetter.Id)
+	require.NoError(t, err)
+	rowsAffected, err := result.RowsAffected()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), rowsAffected)
+
+	_, err = deadLetterStore.ById(ctx, deadLetter.Id)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	result, err = deadLetterStore.Purge(ctx)
+	require.NoError(t, err)
+	rowsAffected, err = result.RowsAffected()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), rowsAffected)
+}
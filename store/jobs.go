@@ -0,0 +1,135 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+// JobStore backs the postgres queue. Received jobs are hidden until visible_at and
+// can only be deleted or extended with the receipt handle of their latest receive.
+type JobStore struct {
+	db *sqlx.DB
+}
+
+func NewJobStore(db *sql.DB) *JobStore {
+	return &JobStore{
+		db: sqlx.NewDb(db, "postgres"),
+	}
+}
+
+type Job struct {
+	Id            int64      `db:"id" json:"id"`
+	Queue         string     `db:"queue" json:"queue"`
+	Body          string     `db:"body" json:"body"`
+	ReceiveCount  int        `db:"receive_count" json:"receive_count"`
+	ReceiptHandle *uuid.UUID `db:"receipt_handle" json:"-"`
+	VisibleAt     time.Time  `db:"visible_at" json:"visible_at"`
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+}
+
+func (s *JobStore) Enqueue(ctx context.Context, queue, body string, delay time.Duration) (*Job, error) {
+	const dml = `INSERT INTO jobs (queue, body, visible_at) VALUES ($1, $2, now() + make_interval(secs => $3)) RETURNING *`
+	var job Job
+
+	if err := s.db.GetContext(ctx, &job, dml, queue, body, delay.Seconds()); err != nil {
+		return nil, fmt.Errorf("failed to enqueue job on %s: %w", queue, err)
+	}
+
+	return &job, nil
+}
+
+// Dequeue claims up to limit visible jobs, hiding them for visibilityTimeout.
+// SKIP LOCKED lets concurrent workers dequeue without blocking on each other.
+func (s *JobStore) Dequeue(ctx context.Context, queue string, limit int, visibilityTimeout time.Duration) ([]Job, error) {
+	const dml = `UPDATE jobs
+							SET
+								receive_count = receive_count + 1,
+								receipt_handle = gen_random_uuid(),
+								visible_at = now() + make_interval(secs => $3)
+							WHERE id IN (
+								SELECT id FROM jobs
+								WHERE queue = $1 AND visible_at <= now()
+								ORDER BY visible_at, id
+								LIMIT $2
+								FOR UPDATE SKIP LOCKED
+							) RETURNING *`
+	jobs := []Job{}
+
+	if err := s.db.SelectContext(ctx, &jobs, dml, queue, limit, visibilityTimeout.Seconds()); err != nil {
+		return nil, fmt.Errorf("failed to dequeue jobs from %s: %w", queue, err)
+	}
+
+	return jobs, nil
+}
+
+// CountVisible returns the number of jobs on queue that can be dequeued right now.
+func (s *JobStore) CountVisible(ctx context.Context, queue string) (int, error) {
+	const query = `SELECT count(*) FROM jobs WHERE queue = $1 AND visible_at <= now()`
+	var count int
+
+	if err := s.db.GetContext(ctx, &count, query, queue); err != nil {
+		return 0, fmt.Errorf("failed to count jobs on %s: %w", queue, err)
+	}
+
+	return count, nil
+}
+
+// Delete removes a received job, sql.ErrNoRows means the receipt handle is stale.
+func (s *JobStore) Delete(ctx context.Context, id int64, receiptHandle uuid.UUID) error {
+	const dml = `DELETE FROM jobs WHERE id = $1 AND receipt_handle = $2`
+
+	result, err := s.db.ExecContext(ctx, dml, id, receiptHandle)
+	if err != nil {
+		return fmt.Errorf("failed to delete job %d: %w", id, err)
+	}
+
+	return requireRowsAffected(result)
+}
+
+// SetVisibility hides a received job for timeout from now, sql.ErrNoRows means the receipt handle is stale.
+func (s *JobStore) SetVisibility(ctx context.Context, id int64, receiptHandle uuid.UUID, timeout time.Duration) error {
+	const dml = `UPDATE jobs SET visible_at = now() + make_interval(secs => $3) WHERE id = $1 AND receipt_handle = $2`
+
+	result, err := s.db.ExecContext(ctx, dml, id, receiptHandle, timeout.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to change visibility of job %d: %w", id, err)
+	}
+
+	return requireRowsAffected(result)
+}
+
+// Defer hides a received job for delay from now and takes back the receive, sql.ErrNoRows
+// means the receipt handle is stale.
+func (s *JobStore) Defer(ctx context.Context, id int64, receiptHandle uuid.UUID, delay time.Duration) error {
+	const dml = `UPDATE jobs
+							SET
+								visible_at = now() + make_interval(secs => $3),
+								receive_count = receive_count - 1
+							WHERE id = $1 AND receipt_handle = $2`
+
+	result, err := s.db.ExecContext(ctx, dml, id, receiptHandle, delay.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to defer job %d: %w", id, err)
+	}
+
+	return requireRowsAffected(result)
+}
+
+func requireRowsAffected(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
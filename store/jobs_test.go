@@ -0,0 +1,64 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/fixtures"
+	"github.com/victor-devv/report-gen/store"
+)
+
+func TestJobStore(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	jobStore := store.NewJobStore(env.Db)
+
+	job, err := jobStore.Enqueue(ctx, "reports", `{"report_id":"1"}`, 0)
+	require.NoError(t, err)
+	require.Equal(t, "reports", job.Queue)
+	require.Equal(t, 0, job.ReceiveCount)
+
+	count, err := jobStore.CountVisible(ctx, "reports")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	jobs, err := jobStore.Dequeue(ctx, "reports", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.Equal(t, job.Id, jobs[0].Id)
+	require.Equal(t, 1, jobs[0].ReceiveCount)
+	require.NotNil(t, jobs[0].ReceiptHandle)
+
+	// dequeued jobs stay hidden until their visibility timeout
+	hidden, err := jobStore.Dequeue(ctx, "reports", 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, hidden)
+
+	count, err = jobStore.CountVisible(ctx, "reports")
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+
+	require.NoError(t, jobStore.SetVisibility(ctx, job.Id, *jobs[0].ReceiptHandle, 0))
+	redelivered, err := jobStore.Dequeue(ctx, "reports", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, redelivered, 1)
+	require.Equal(t, 2, redelivered[0].ReceiveCount)
+
+	// deferring takes back the receive
+	require.NoError(t, jobStore.Defer(ctx, job.Id, *redelivered[0].ReceiptHandle, 0))
+	deferred, err := jobStore.Dequeue(ctx, "reports", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, deferred, 1)
+	require.Equal(t, 2, deferred[0].ReceiveCount)
+
+	require.ErrorIs(t, jobStore.Delete(ctx, job.Id, *jobs[0].ReceiptHandle), sql.ErrNoRows)
+	require.NoError(t, jobStore.Delete(ctx, job.Id, *deferred[0].ReceiptHandle))
+}
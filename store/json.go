@@ -0,0 +1,53 @@
+package store
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Json stores Val in a JSONB column and (un)marshals transparently in api payloads.
+type Json[T any] struct {
+	Val T
+}
+
+func NewJson[T any](v T) Json[T] {
+	return Json[T]{Val: v}
+}
+
+func (j Json[T]) Value() (driver.Value, error) {
+	b, err := json.Marshal(j.Val)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal json column: %w", err)
+	}
+	return b, nil
+}
+
+func (j *Json[T]) Scan(src any) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		var zero T
+		j.Val = zero
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("unsupported json column type %T", src)
+	}
+
+	if err := json.Unmarshal(b, &j.Val); err != nil {
+		return fmt.Errorf("failed to unmarshal json column: %w", err)
+	}
+	return nil
+}
+
+func (j Json[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(j.Val)
+}
+
+func (j *Json[T]) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, &j.Val)
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+// OutboxStore holds queue messages written in the same transaction as the rows they
+// refer to, until the relay has published them.
+type OutboxStore struct {
+	db *sqlx.DB
+}
+
+func NewOutboxStore(db *sql.DB) *OutboxStore {
+	return &OutboxStore{
+		db: sqlx.NewDb(db, "postgres"),
+	}
+}
+
+type OutboxMessage struct {
+	Id          int64      `db:"id" json:"id"`
+	Body        string     `db:"body" json:"body"`
+	Attempts    int        `db:"attempts" json:"attempts"`
+	LastError   *string    `db:"last_error" json:"last_error"`
+	AvailableAt time.Time  `db:"available_at" json:"available_at"`
+	SentAt      *time.Time `db:"sent_at" json:"sent_at"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+}
+
+func insertOutboxMessage(ctx context.Context, tx *sqlx.Tx, body string) error {
+	const dml = `INSERT INTO outbox (body) VALUES ($1)`
+
+	if _, err := tx.ExecContext(ctx, dml, body); err != nil {
+		return fmt.Errorf("failed to insert outbox message: %w", err)
+	}
+
+	return nil
+}
+
+// Claim leases up to limit unsent messages for lease and counts the attempt. A message
+// whose relay dies before marking it becomes available again once the lease expires.
+func (s *OutboxStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]OutboxMessage, error) {
+	const dml = `UPDATE outbox
+							SET
+								attempts = attempts + 1,
+								available_at = now() + make_interval(secs => $2)
+							WHERE id IN (
+								SELECT id FROM outbox
+								WHERE sent_at IS NULL AND available_at <= now()
+								ORDER BY id
+								LIMIT $1
+								FOR UPDATE SKIP LOCKED
+							) RETURNING *`
+	messages := []OutboxMessage{}
+
+	if err := s.db.SelectContext(ctx, &messages, dml, limit, lease.Seconds()); err != nil {
+		return nil, fmt.Errorf("failed to claim outbox messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+func (s *OutboxStore) MarkSent(ctx context.Context, id int64) error {
+	const dml = `UPDATE outbox SET sent_at = now(), last_error = NULL WHERE id = $1`
+
+	if _, err := s.db.ExecContext(ctx, dml, id); err != nil {
+		return fmt.Errorf("failed to mark outbox message %d as sent: %w", id, err)
+	}
+
+	return nil
+}
+
+// MarkFailed records the send error and makes the message available again after retryIn.
+func (s *OutboxStore) MarkFailed(ctx context.Context, id int64, lastError string, retryIn time.Duration) error {
+	const dml = `UPDATE outbox SET last_error = $2, available_at = now() + make_interval(secs => $3) WHERE id = $1`
+
+	if _, err := s.db.ExecContext(ctx, dml, id, lastError, retryIn.Seconds()); err != nil {
+		return fmt.Errorf("failed to mark outbox message %d as failed: %w", id, err)
+	}
+
+	return nil
+}
+
+// DeleteSentBefore removes messages sent before before and returns how many were deleted.
+func (s *OutboxStore) DeleteSentBefore(ctx context.Context, before time.Time) (int64, error) {
+	const dml = `DELETE FROM outbox WHERE sent_at < $1`
+
+	result, err := s.db.ExecContext(ctx, dml, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sent outbox messages: %w", err)
+	}
+
+	return result.RowsAffected()
+}
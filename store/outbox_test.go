@@ -0,0 +1,63 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/victor-devv/report-gen/fixtures"
+	"github.com/victor-devv/report-gen/store"
+)
+
+func TestOutboxStore(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	userStore := store.NewUserStore(env.Db)
+	reportStore := store.NewReportStore(env.Db)
+	outboxStore := store.NewOutboxStore(env.Db)
+
+	user, err := userStore.Create(ctx, "test@testemail.com", "testPassword")
+	require.NoError(t, err)
+
+	report, err := reportStore.CreateAndEnqueue(ctx, user.Id, "monsters", store.ReportOptions{}, nil, func(report *store.Report) (string, error) {
+		return report.Id.String(), nil
+	})
+	require.NoError(t, err)
+
+	// the report and its message are written together or not at all
+	_, err = reportStore.CreateAndEnqueue(ctx, user.Id, "monsters", store.ReportOptions{}, nil, func(report *store.Report) (string, error) {
+		return "", errors.New("boom")
+	})
+	require.Error(t, err)
+
+	messages, err := outboxStore.Claim(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, report.Id.String(), messages[0].Body)
+	require.Equal(t, 1, messages[0].Attempts)
+
+	// claimed messages are leased to a single relay
+	leased, err := outboxStore.Claim(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, leased)
+
+	require.NoError(t, outboxStore.MarkFailed(ctx, messages[0].Id, "queue unavailable", 0))
+	retried, err := outboxStore.Claim(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, retried, 1)
+	require.Equal(t, 2, retried[0].Attempts)
+	require.Equal(t, "queue unavailable", *retried[0].LastError)
+
+	require.NoError(t, outboxStore.MarkSent(ctx, retried[0].Id))
+	require.NoError(t, outboxStore.MarkFailed(ctx, retried[0].Id, "late failure", 0))
+	sent, err := outboxStore.Claim(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, sent)
+}
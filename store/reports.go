@@ -3,12 +3,14 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type ReportStore struct {
@@ -21,81 +23,536 @@ func NewReportStore(db *sql.DB) *ReportStore {
 	}
 }
 
+// ReportOptions are per report build switches chosen by the user at creation time.
+type ReportOptions struct {
+	Enrich bool `json:"enrich"`
+}
+
 // nullables should be pointers
 type Report struct {
-	Id                   uuid.UUID  `db:"id" json:"id"`
-	UserId               uuid.UUID  `db:"user_id" json:"user_id"`
-	ReportType           string     `db:"report_type" json:"report_type"`
-	OutputFilePath       *string    `db:"output_file_path" json:"output_file_path"`
-	DownloadUrl          *string    `db:"download_url" json:"download_url"`
-	DownloadUrlExpiresAt *time.Time `db:"download_url_expires_at" json:"download_url_expires_at"`
-	ErrorMessage         *string    `db:"error_message" json:"error_message"`
-	CreatedAt            time.Time  `db:"created_at" json:"created_at"`
-	StartedAt            *time.Time `db:"started_at" json:"started_at"`
-	FailedAt             *time.Time `db:"failed_at" json:"failed_at"`
-	CompletedAt          *time.Time `db:"completed_at" json:"completed_at"`
+	Id                 uuid.UUID           `db:"id" json:"id"`
+	UserId             uuid.UUID           `db:"user_id" json:"user_id"`
+	ReportType         string              `db:"report_type" json:"report_type"`
+	OutputFilePath     *string             `db:"output_file_path" json:"output_file_path"`
+	ErrorMessage       *string             `db:"error_message" json:"error_message"`
+	CreatedAt          time.Time           `db:"created_at" json:"created_at"`
+	StartedAt          *time.Time          `db:"started_at" json:"started_at"`
+	FailedAt           *time.Time          `db:"failed_at" json:"failed_at"`
+	CompletedAt        *time.Time          `db:"completed_at" json:"completed_at"`
+	Options            Json[ReportOptions] `db:"options" json:"options"`
+	QuarantineFilePath *string             `db:"quarantine_file_path" json:"quarantine_file_path"`
+	QuarantinedRows    int                 `db:"quarantined_rows" json:"quarantined_rows"`
+	ErrorCode          *string             `db:"error_code" json:"error_code"`
+	Priority           int                 `db:"priority" json:"priority"`
+	Attempts           int                 `db:"attempts" json:"attempts"`
+	EnqueuedAt         *time.Time          `db:"enqueued_at" json:"enqueued_at"`
+	ClaimedBy          *string             `db:"claimed_by" json:"claimed_by"`
+	LeaseExpiresAt     *time.Time          `db:"lease_expires_at" json:"lease_expires_at"`
+	OutputBytes        int64               `db:"output_bytes" json:"output_bytes"`
+	Labels             pq.StringArray      `db:"labels" json:"labels"`
 }
 
 func (r *Report) IsDone() bool {
 	return r.CompletedAt != nil || r.FailedAt != nil
 }
 
+// Status is derived from the timestamps, a done report is completed or failed even
+// if it never started, e.g. when the reaper gave up on it while it was pending.
 func (r *Report) Status() string {
 	switch {
-	case r.StartedAt == nil:
-		return "pending"
-	case r.StartedAt != nil && !r.IsDone():
-		return "processing"
 	case r.CompletedAt != nil:
 		return "completed"
 	case r.FailedAt != nil:
 		return "failed"
+	case r.StartedAt == nil:
+		return "pending"
 	}
-	return "unknown"
+	return "processing"
 }
 
-func (s *ReportStore) Create(ctx context.Context, user_id uuid.UUID, reportType string) (*Report, error) {
-	const dml = `INSERT INTO reports (user_id, report_type) VALUES ($1, $2) RETURNING *`
+const createReportDml = `INSERT INTO reports (user_id, report_type, options, labels) VALUES ($1, $2, $3, $4) RETURNING *`
+
+func (s *ReportStore) Create(ctx context.Context, user_id uuid.UUID, reportType string, options ReportOptions) (*Report, error) {
 	var report Report
 
-	if err := s.db.GetContext(ctx, &report, dml, user_id, reportType); err != nil {
+	if err := s.db.GetContext(ctx, &report, createReportDml, user_id, reportType, NewJson(options), pq.StringArray{}); err != nil {
 		return nil, fmt.Errorf("failed to create report: %w", err)
 	}
 
 	return &report, nil
 }
 
+// CreateAndEnqueue creates a report labelled with labels and, in the same transaction, an
+// outbox message with the body built by message. The outbox relay publishes it to the
+// queue, so the report is enqueued at least once even when the queue is unavailable right now.
+func (s *ReportStore) CreateAndEnqueue(ctx context.Context, userId uuid.UUID, reportType string, options ReportOptions, labels []string, message func(*Report) (string, error)) (*Report, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if labels == nil {
+		labels = []string{}
+	}
+
+	var report Report
+	if err := tx.GetContext(ctx, &report, createReportDml, userId, reportType, NewJson(options), pq.StringArray(labels)); err != nil {
+		return nil, fmt.Errorf("failed to create report: %w", err)
+	}
+
+	body, err := message(&report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build message for report %s: %w", report.Id, err)
+	}
+
+	if err := insertOutboxMessage(ctx, tx, body); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit report %s: %w", report.Id, err)
+	}
+
+	return &report, nil
+}
+
 func (s *ReportStore) Update(ctx context.Context, report *Report) (*Report, error) {
 	const dml = `UPDATE reports 
 							SET 
 								output_file_path = $1, 
-								download_url = $2, 
-								download_url_expires_at = $3, 
-								error_message = $4, 
-								started_at = $5, 
-								completed_at = $6, 
-								failed_at = $7 
-							WHERE user_id = $8 AND id = $9 RETURNING *`
+								error_message = $2, 
+								started_at = $3, 
+								completed_at = $4, 
+								failed_at = $5, 
+								quarantine_file_path = $6, 
+								quarantined_rows = $7, 
+								error_code = $8, 
+								output_bytes = $9 
+							WHERE user_id = $10 AND id = $11 RETURNING *`
 
 	var updatedReport Report
 
 	if err := s.db.GetContext(ctx, &updatedReport, dml,
 		report.OutputFilePath,
-		report.DownloadUrl,
-		report.DownloadUrlExpiresAt,
 		report.ErrorMessage,
 		report.StartedAt,
 		report.CompletedAt,
 		report.FailedAt,
+		report.QuarantineFilePath,
+		report.QuarantinedRows,
+		report.ErrorCode,
+		report.OutputBytes,
+		report.UserId,
+		report.Id,
+	); err != nil {
+		return nil, fmt.Errorf("failed to update report: %w", err)
+	}
+
+	return &updatedReport, nil
+}
+
+// Claim starts the report for workerId unless it is done or another worker holds an
+// unexpired lease on it, which is reported as sql.ErrNoRows. The claim clears the
+// results of any previous attempt.
+func (s *ReportStore) Claim(ctx context.Context, userId, id uuid.UUID, workerId string, lease time.Duration) (*Report, error) {
+	const dml = `UPDATE reports
+							SET
+								started_at = now(),
+								claimed_by = $3,
+								lease_expires_at = now() + make_interval(secs => $4),
+								output_file_path = NULL,
+								error_message = NULL,
+								completed_at = NULL,
+								failed_at = NULL,
+								quarantine_file_path = NULL,
+								quarantined_rows = 0,
+								error_code = NULL,
+								output_bytes = 0
+							WHERE user_id = $1 AND id = $2
+								AND completed_at IS NULL AND failed_at IS NULL
+								AND (started_at IS NULL OR lease_expires_at < now())
+							RETURNING *`
+	var report Report
+
+	if err := s.db.GetContext(ctx, &report, dml, userId, id, workerId, lease.Seconds()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to claim report %s for user %s: %w", id, userId, err)
+	}
+
+	return &report, nil
+}
+
+// UpdateClaimed is Update for the worker holding the report's current claim, sql.ErrNoRows
+// means the claim was lost, e.g. the lease expired and another worker claimed the report.
+func (s *ReportStore) UpdateClaimed(ctx context.Context, report *Report) (*Report, error) {
+	const dml = `UPDATE reports
+							SET
+								output_file_path = $1,
+								error_message = $2,
+								completed_at = $3,
+								failed_at = $4,
+								quarantine_file_path = $5,
+								quarantined_rows = $6,
+								error_code = $7,
+								output_bytes = $8
+							WHERE user_id = $9 AND id = $10 AND claimed_by = $11 AND started_at = $12
+							RETURNING *`
+
+	var updatedReport Report
+
+	if err := s.db.GetContext(ctx, &updatedReport, dml,
+		report.OutputFilePath,
+		report.ErrorMessage,
+		report.CompletedAt,
+		report.FailedAt,
+		report.QuarantineFilePath,
+		report.QuarantinedRows,
+		report.ErrorCode,
+		report.OutputBytes,
 		report.UserId,
 		report.Id,
+		report.ClaimedBy,
+		report.StartedAt,
 	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to update report: %w", err)
 	}
 
 	return &updatedReport, nil
 }
 
+// Stuck returns unfinished reports whose claim lease expired, i.e. the worker building
+// them is gone, or that are still pending since before pendingBefore. Reports claimed
+// before leases existed have none, they are stuck once started before processingBefore.
+func (s *ReportStore) Stuck(ctx context.Context, processingBefore, pendingBefore time.Time, limit int) ([]Report, error) {
+	const query = `SELECT * FROM reports
+								WHERE completed_at IS NULL AND failed_at IS NULL AND (
+									(started_at IS NOT NULL AND (lease_expires_at < now() OR (lease_expires_at IS NULL AND started_at < $1))) OR
+									(started_at IS NULL AND COALESCE(enqueued_at, created_at) < $2)
+								)
+								ORDER BY created_at
+								LIMIT $3`
+	reports := []Report{}
+
+	if err := s.db.SelectContext(ctx, &reports, query, processingBefore, pendingBefore, limit); err != nil {
+		return nil, fmt.Errorf("failed to find stuck reports: %w", err)
+	}
+
+	return reports, nil
+}
+
+// Requeue resets a stuck report to pending, counts the attempt and writes an outbox
+// message built by message in the same transaction. sql.ErrNoRows means the report
+// changed since it was read, e.g. a worker picked it up or another reaper got there first.
+func (s *ReportStore) Requeue(ctx context.Context, report *Report, message func(*Report) (string, error)) (*Report, error) {
+	const dml = `UPDATE reports
+							SET
+								started_at = NULL,
+								claimed_by = NULL,
+								lease_expires_at = NULL,
+								attempts = attempts + 1,
+								enqueued_at = now()
+							WHERE user_id = $1 AND id = $2
+								AND completed_at IS NULL AND failed_at IS NULL
+								AND started_at IS NOT DISTINCT FROM $3 AND attempts = $4
+							RETURNING *`
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var requeued Report
+	if err := tx.GetContext(ctx, &requeued, dml, report.UserId, report.Id, report.StartedAt, report.Attempts); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to requeue report %s: %w", report.Id, err)
+	}
+
+	body, err := message(&requeued)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build message for report %s: %w", report.Id, err)
+	}
+
+	if err := insertOutboxMessage(ctx, tx, body); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit report %s: %w", report.Id, err)
+	}
+
+	return &requeued, nil
+}
+
+// FailStuck marks a stuck report failed with errorMessage and errorCode. Like Requeue it only
+// touches the report as it was read, sql.ErrNoRows means it changed since, e.g. a worker
+// completed it or another reaper got there first.
+func (s *ReportStore) FailStuck(ctx context.Context, report *Report, errorMessage, errorCode string) (*Report, error) {
+	const dml = `UPDATE reports
+							SET
+								failed_at = now(),
+								error_message = $5,
+								error_code = $6
+							WHERE user_id = $1 AND id = $2
+								AND completed_at IS NULL AND failed_at IS NULL
+								AND started_at IS NOT DISTINCT FROM $3 AND attempts = $4
+							RETURNING *`
+	var failed Report
+
+	if err := s.db.GetContext(ctx, &failed, dml, report.UserId, report.Id, report.StartedAt, report.Attempts, errorMessage, errorCode); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to fail report %s: %w", report.Id, err)
+	}
+
+	return &failed, nil
+}
+
+// SetPriority changes the priority of a report that has not started yet, sql.ErrNoRows
+// means there is no such pending report.
+func (s *ReportStore) SetPriority(ctx context.Context, id uuid.UUID, priority int) (*Report, error) {
+	const dml = `UPDATE reports SET priority = $1 WHERE id = $2 AND started_at IS NULL AND completed_at IS NULL AND failed_at IS NULL RETURNING *`
+	var report Report
+
+	if err := s.db.GetContext(ctx, &report, dml, priority, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to set priority of report %s: %w", id, err)
+	}
+
+	return &report, nil
+}
+
+// Priorities returns the current priority of the given reports by id, reports that
+// don't exist are left out.
+func (s *ReportStore) Priorities(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]int, error) {
+	const query = `SELECT id, priority FROM reports WHERE id = ANY($1::uuid[])`
+	var rows []struct {
+		Id       uuid.UUID `db:"id"`
+		Priority int       `db:"priority"`
+	}
+
+	keys := make(pq.StringArray, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, id.String())
+	}
+
+	if err := s.db.SelectContext(ctx, &rows, query, keys); err != nil {
+		return nil, fmt.Errorf("failed to load report priorities: %w", err)
+	}
+
+	priorities := make(map[uuid.UUID]int, len(rows))
+	for _, row := range rows {
+		priorities[row.Id] = row.Priority
+	}
+	return priorities, nil
+}
+
+// ReportCursor is the position of a report in a listing, sorted by creation time and id.
+type ReportCursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	Id        uuid.UUID `json:"id"`
+	// Ascending is the sort of the listing the cursor belongs to
+	Ascending bool `json:"ascending"`
+}
+
+// ReportFilter selects and orders the reports returned by List. Zero values don't filter.
+type ReportFilter struct {
+	// Status is one of pending, processing, completed or failed, see Report.Status
+	Status     string
+	ReportType string
+	// Labels the reports must all have
+	Labels []string
+	// CreatedAfter and CreatedBefore are both exclusive
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// Ascending lists the oldest reports first, the newest come first by default
+	Ascending bool
+	// After continues a listing after the last report of the previous page, it must
+	// have the same sort
+	After *ReportCursor
+	// Limit is the page size, zero lists DefaultReportPageSize reports
+	Limit int
+}
+
+// ErrCursorSortMismatch is returned by List for a cursor of a listing with the other sort.
+var ErrCursorSortMismatch = errors.New("cursor belongs to a listing with a different sort")
+
+// DefaultReportPageSize is the page size of List when the filter has no limit.
+const DefaultReportPageSize = 20
+
+var reportStatusConditions = map[string]string{
+	"pending":    "started_at IS NULL AND completed_at IS NULL AND failed_at IS NULL",
+	"processing": "started_at IS NOT NULL AND completed_at IS NULL AND failed_at IS NULL",
+	"completed":  "completed_at IS NOT NULL",
+	"failed":     "completed_at IS NULL AND failed_at IS NOT NULL",
+}
+
+// List returns a page of the reports of a user matching filter and the cursor of the
+// next page, which is nil on the last page.
+func (s *ReportStore) List(ctx context.Context, userId uuid.UUID, filter ReportFilter) ([]Report, *ReportCursor, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultReportPageSize
+	}
+
+	conditions := []string{"user_id = $1"}
+	args := []any{userId}
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Status != "" {
+		condition, ok := reportStatusConditions[filter.Status]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown report status %q", filter.Status)
+		}
+		conditions = append(conditions, condition)
+	}
+
+	if filter.ReportType != "" {
+		conditions = append(conditions, "report_type = "+arg(filter.ReportType))
+	}
+
+	if len(filter.Labels) > 0 {
+		conditions = append(conditions, "labels @> "+arg(pq.StringArray(filter.Labels)))
+	}
+
+	if filter.CreatedAfter != nil {
+		conditions = append(conditions, "created_at > "+arg(*filter.CreatedAfter))
+	}
+
+	if filter.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < "+arg(*filter.CreatedBefore))
+	}
+
+	order, direction := "DESC", "<"
+	if filter.Ascending {
+		order, direction = "ASC", ">"
+	}
+
+	if filter.After != nil {
+		if filter.After.Ascending != filter.Ascending {
+			return nil, nil, ErrCursorSortMismatch
+		}
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) %s (%s, %s)", direction, arg(filter.After.CreatedAt), arg(filter.After.Id)))
+	}
+
+	// one extra row tells whether there is a next page
+	query := fmt.Sprintf(`SELECT * FROM reports WHERE %s ORDER BY created_at %s, id %s LIMIT %s`,
+		strings.Join(conditions, " AND "), order, order, arg(filter.Limit+1))
+	reports := []Report{}
+
+	if err := s.db.SelectContext(ctx, &reports, query, args...); err != nil {
+		return nil, nil, fmt.Errorf("failed to list reports of user %s: %w", userId, err)
+	}
+
+	if len(reports) <= filter.Limit {
+		return reports, nil, nil
+	}
+
+	reports = reports[:filter.Limit]
+	last := reports[len(reports)-1]
+	return reports, &ReportCursor{CreatedAt: last.CreatedAt, Id: last.Id, Ascending: filter.Ascending}, nil
+}
+
+// WithOutput pages through the reports of all users that have an output file, in id order
+// starting after afterId. Pass uuid.Nil for the first page.
+func (s *ReportStore) WithOutput(ctx context.Context, afterId uuid.UUID, limit int) ([]Report, error) {
+	const query = `SELECT * FROM reports WHERE output_file_path IS NOT NULL AND id > $1 ORDER BY id LIMIT $2`
+	reports := []Report{}
+
+	if err := s.db.SelectContext(ctx, &reports, query, afterId, limit); err != nil {
+		return nil, fmt.Errorf("failed to list reports with output: %w", err)
+	}
+
+	return reports, nil
+}
+
+// WithoutOutputBytes pages through the reports of all users that have files but no recorded
+// output size, i.e. were built before sizes were recorded, in id order starting after afterId.
+func (s *ReportStore) WithoutOutputBytes(ctx context.Context, afterId uuid.UUID, limit int) ([]Report, error) {
+	const query = `SELECT * FROM reports
+								WHERE (output_file_path IS NOT NULL OR quarantine_file_path IS NOT NULL)
+									AND output_bytes = 0 AND id > $1
+								ORDER BY id
+								LIMIT $2`
+	reports := []Report{}
+
+	if err := s.db.SelectContext(ctx, &reports, query, afterId, limit); err != nil {
+		return nil, fmt.Errorf("failed to list reports without output bytes: %w", err)
+	}
+
+	return reports, nil
+}
+
+// SetOutputBytes records the size of the files of a report.
+func (s *ReportStore) SetOutputBytes(ctx context.Context, userId, id uuid.UUID, outputBytes int64) error {
+	const dml = `UPDATE reports SET output_bytes = $1 WHERE user_id = $2 AND id = $3`
+
+	if _, err := s.db.ExecContext(ctx, dml, outputBytes, userId, id); err != nil {
+		return fmt.Errorf("failed to set output bytes of report %s: %w", id, err)
+	}
+
+	return nil
+}
+
+// Usage sums up the reports of a user.
+type Usage struct {
+	OutputBytes  int64 `db:"output_bytes" json:"output_bytes"`
+	Reports      int   `db:"reports" json:"reports"`
+	ReportsSince int   `db:"reports_since" json:"reports_since"`
+}
+
+// Usage returns the bytes of report output a user holds, how many reports they have and
+// how many of those were created since since.
+func (s *ReportStore) Usage(ctx context.Context, userId uuid.UUID, since time.Time) (*Usage, error) {
+	const query = `SELECT
+									COALESCE(SUM(output_bytes), 0) AS output_bytes,
+									COUNT(*) AS reports,
+									COUNT(*) FILTER (WHERE created_at >= $2) AS reports_since
+								FROM reports WHERE user_id = $1`
+	var usage Usage
+
+	if err := s.db.GetContext(ctx, &usage, query, userId, since); err != nil {
+		return nil, fmt.Errorf("failed to get usage of user %s: %w", userId, err)
+	}
+
+	return &usage, nil
+}
+
+type DailyReportCount struct {
+	Day     time.Time `db:"day" json:"day"`
+	Reports int       `db:"reports" json:"reports"`
+}
+
+// DailyReportCounts returns how many reports a user created on each utc day since since,
+// days without reports are left out.
+func (s *ReportStore) DailyReportCounts(ctx context.Context, userId uuid.UUID, since time.Time) ([]DailyReportCount, error) {
+	const query = `SELECT date_trunc('day', created_at AT TIME ZONE 'UTC') AS day, COUNT(*) AS reports
+								FROM reports
+								WHERE user_id = $1 AND created_at >= $2
+								GROUP BY day
+								ORDER BY day`
+	counts := []DailyReportCount{}
+
+	if err := s.db.SelectContext(ctx, &counts, query, userId, since); err != nil {
+		return nil, fmt.Errorf("failed to count daily reports of user %s: %w", userId, err)
+	}
+
+	return counts, nil
+}
+
 func (s *ReportStore) ByPrimaryKey(ctx context.Context, id, userId uuid.UUID) (*Report, error) {
 	const query = `SELECT * FROM reports WHERE id = $1 AND user_id = $2`
 
@@ -2,9 +2,11 @@ package store_test
 
 import (
 	"context"
+	"database/sql"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 	"github.com/victor-devv/report-gen/fixtures"
 	"github.com/victor-devv/report-gen/store"
@@ -26,11 +28,21 @@ func TestReportStore(t *testing.T) {
 	require.NoError(t, err)
 
 	now := time.Now().UTC()
-	report, err := reportStore.Create(ctx, user.Id, "monsters")
+	report, err := reportStore.Create(ctx, user.Id, "monsters", store.ReportOptions{Enrich: true})
 	after := time.Now().UTC()
 	require.NoError(t, err)
 	require.Equal(t, user.Id, report.UserId)
 	require.Equal(t, "monsters", report.ReportType)
+	require.True(t, report.Options.Val.Enrich)
+	require.Equal(t, 0, report.Priority)
+
+	bumped, err := reportStore.SetPriority(ctx, report.Id, 10)
+	require.NoError(t, err)
+	require.Equal(t, 10, bumped.Priority)
+
+	priorities, err := reportStore.Priorities(ctx, []uuid.UUID{report.Id, uuid.New()})
+	require.NoError(t, err)
+	require.Equal(t, map[uuid.UUID]int{report.Id: 10}, priorities)
 
 	// VERY FLAKY
 	// TODO DEBUG
@@ -44,18 +56,19 @@ func TestReportStore(t *testing.T) {
 	completedAt := report.CreatedAt.Add(2 * time.Second)
 	failedAt := report.CreatedAt.Add(3 * time.Second)
 	errMsg := "an error occurred"
-	downloadUrl := "https://example.com/reports/123/download"
 	outputPath := "s3://reports-test/reports"
-	downloadUrlExpiresAt := report.CreatedAt.Add(4 * time.Second)
+	quarantinePath := "s3://reports-test/reports.quarantine"
+	errCode := "build_timeout"
 
 	report.ReportType = "food"
 	report.StartedAt = &startedAt
 	report.CompletedAt = &completedAt
 	report.FailedAt = &failedAt
 	report.ErrorMessage = &errMsg
-	report.DownloadUrl = &downloadUrl
 	report.OutputFilePath = &outputPath
-	report.DownloadUrlExpiresAt = &downloadUrlExpiresAt
+	report.QuarantineFilePath = &quarantinePath
+	report.QuarantinedRows = 2
+	report.ErrorCode = &errCode
 
 	updatedReport, err := reportStore.Update(ctx, report)
 	require.NoError(t, err)
@@ -68,9 +81,7 @@ func TestReportStore(t *testing.T) {
 	require.Equal(t, report.CompletedAt.UnixNano(), updatedReport.CompletedAt.UnixNano())
 	require.Equal(t, report.FailedAt.UnixNano(), updatedReport.FailedAt.UnixNano())
 	require.Equal(t, &errMsg, report.ErrorMessage)
-	require.Equal(t, &downloadUrl, report.DownloadUrl)
 	require.Equal(t, &outputPath, report.OutputFilePath)
-	require.Equal(t, (&downloadUrlExpiresAt).UnixNano(), report.DownloadUrlExpiresAt.UnixNano())
 
 	report3, err := reportStore.ByPrimaryKey(ctx, report.Id, report.UserId)
 	require.NoError(t, err)
@@ -83,7 +94,337 @@ func TestReportStore(t *testing.T) {
 	require.Equal(t, report.CompletedAt.UnixNano(), report3.CompletedAt.UnixNano())
 	require.Equal(t, report.FailedAt.UnixNano(), report3.FailedAt.UnixNano())
 	require.Equal(t, &errMsg, report3.ErrorMessage)
-	require.Equal(t, &downloadUrl, report3.DownloadUrl)
 	require.Equal(t, &outputPath, report3.OutputFilePath)
-	require.Equal(t, (&downloadUrlExpiresAt).UnixNano(), report3.DownloadUrlExpiresAt.UnixNano())
+	require.Equal(t, &quarantinePath, report3.QuarantineFilePath)
+	require.Equal(t, 2, report3.QuarantinedRows)
+	require.Equal(t, &errCode, report3.ErrorCode)
+	require.Equal(t, 10, report3.Priority)
+
+	// only pending reports can be bumped
+	_, err = reportStore.SetPriority(ctx, report.Id, 20)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestReportStoreStuck(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	userStore := store.NewUserStore(env.Db)
+	reportStore := store.NewReportStore(env.Db)
+
+	user, err := userStore.Create(ctx, "test@testemail.com", "testPassword")
+	require.NoError(t, err)
+
+	report, err := reportStore.Create(ctx, user.Id, "monsters", store.ReportOptions{})
+	require.NoError(t, err)
+
+	now := time.Now()
+	stuck, err := reportStore.Stuck(ctx, now, now.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, stuck, 1)
+	require.Equal(t, report.Id, stuck[0].Id)
+
+	requeued, err := reportStore.Requeue(ctx, &stuck[0], func(report *store.Report) (string, error) {
+		return report.Id.String(), nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, requeued.Attempts)
+	require.NotNil(t, requeued.EnqueuedAt)
+	require.Nil(t, requeued.StartedAt)
+
+	// a second reaper working from the same read loses
+	_, err = reportStore.Requeue(ctx, &stuck[0], func(report *store.Report) (string, error) {
+		return report.Id.String(), nil
+	})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// requeueing counts as queue activity
+	stuck, err = reportStore.Stuck(ctx, now, now.Add(-time.Minute), 10)
+	require.NoError(t, err)
+	require.Empty(t, stuck)
+
+	// a live claim is not stuck however long ago it started
+	claimed, err := reportStore.Claim(ctx, user.Id, report.Id, "worker-a", time.Minute)
+	require.NoError(t, err)
+	stuck, err = reportStore.Stuck(ctx, time.Now().Add(time.Hour), now.Add(-time.Minute), 10)
+	require.NoError(t, err)
+	require.Empty(t, stuck)
+
+	// failing from a stale read loses to a worker that completed the report meanwhile
+	completedAt := time.Now()
+	claimed.CompletedAt = &completedAt
+	_, err = reportStore.UpdateClaimed(ctx, claimed)
+	require.NoError(t, err)
+
+	_, err = reportStore.FailStuck(ctx, requeued, "stuck", "stuck")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	report2, err := reportStore.Create(ctx, user.Id, "monsters", store.ReportOptions{})
+	require.NoError(t, err)
+	failed, err := reportStore.FailStuck(ctx, report2, "stuck", "stuck")
+	require.NoError(t, err)
+	require.NotNil(t, failed.FailedAt)
+	require.Equal(t, "stuck", *failed.ErrorCode)
+	// it never started but is failed, not pending
+	require.Equal(t, "failed", failed.Status())
+
+	listed, _, err := reportStore.List(ctx, user.Id, store.ReportFilter{Status: "failed", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	require.Equal(t, report2.Id, listed[0].Id)
+
+	// an expired claim is stuck right away
+	report3, err := reportStore.Create(ctx, user.Id, "monsters", store.ReportOptions{})
+	require.NoError(t, err)
+	_, err = reportStore.Claim(ctx, user.Id, report3.Id, "worker-a", 0)
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	stuck, err = reportStore.Stuck(ctx, now.Add(-time.Hour), now.Add(-time.Hour), 10)
+	require.NoError(t, err)
+	require.Len(t, stuck, 1)
+	require.Equal(t, report3.Id, stuck[0].Id)
+}
+
+func TestReportStoreClaim(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	userStore := store.NewUserStore(env.Db)
+	reportStore := store.NewReportStore(env.Db)
+
+	user, err := userStore.Create(ctx, "test@testemail.com", "testPassword")
+	require.NoError(t, err)
+
+	report, err := reportStore.Create(ctx, user.Id, "monsters", store.ReportOptions{})
+	require.NoError(t, err)
+
+	claimed, err := reportStore.Claim(ctx, user.Id, report.Id, "worker-a", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, claimed.StartedAt)
+	require.Equal(t, "worker-a", *claimed.ClaimedBy)
+	require.True(t, claimed.LeaseExpiresAt.After(*claimed.StartedAt))
+
+	// a duplicate delivery can't claim the report while the lease holds
+	_, err = reportStore.Claim(ctx, user.Id, report.Id, "worker-b", time.Minute)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// only the claimant can finish the report
+	intruder := *claimed
+	otherWorker := "worker-b"
+	intruder.ClaimedBy = &otherWorker
+	now := time.Now()
+	intruder.CompletedAt = &now
+	_, err = reportStore.UpdateClaimed(ctx, &intruder)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	claimed.CompletedAt = &now
+	completed, err := reportStore.UpdateClaimed(ctx, claimed)
+	require.NoError(t, err)
+	require.Equal(t, "completed", completed.Status())
+
+	_, err = reportStore.Claim(ctx, user.Id, report.Id, "worker-b", time.Minute)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// an expired lease can be taken over, after which the old claimant is locked out
+	report2, err := reportStore.Create(ctx, user.Id, "monsters", store.ReportOptions{})
+	require.NoError(t, err)
+	expired, err := reportStore.Claim(ctx, user.Id, report2.Id, "worker-a", 0)
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	takenOver, err := reportStore.Claim(ctx, user.Id, report2.Id, "worker-b", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "worker-b", *takenOver.ClaimedBy)
+
+	expired.FailedAt = &now
+	_, err = reportStore.UpdateClaimed(ctx, expired)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestReportStoreUsage(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	reportStore := store.NewReportStore(env.Db)
+	userStore := store.NewUserStore(env.Db)
+
+	user, err := userStore.Create(ctx, "test@testemail.com", "testPassword")
+	require.NoError(t, err)
+	other, err := userStore.Create(ctx, "other@testemail.com", "testPassword")
+	require.NoError(t, err)
+
+	usage, err := reportStore.Usage(ctx, user.Id, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, store.Usage{}, *usage)
+
+	completed, err := reportStore.Create(ctx, user.Id, "monsters", store.ReportOptions{})
+	require.NoError(t, err)
+	completedAt := time.Now()
+	completed.CompletedAt = &completedAt
+	completed.OutputBytes = 1024
+	_, err = reportStore.Update(ctx, completed)
+	require.NoError(t, err)
+
+	_, err = reportStore.Create(ctx, user.Id, "monsters", store.ReportOptions{})
+	require.NoError(t, err)
+
+	// other users' reports don't count
+	_, err = reportStore.Create(ctx, other.Id, "monsters", store.ReportOptions{})
+	require.NoError(t, err)
+
+	usage, err = reportStore.Usage(ctx, user.Id, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, store.Usage{OutputBytes: 1024, Reports: 2, ReportsSince: 2}, *usage)
+
+	usage, err = reportStore.Usage(ctx, user.Id, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 0, usage.ReportsSince)
+
+	counts, err := reportStore.DailyReportCounts(ctx, user.Id, time.Now().Add(-24*time.Hour))
+	require.NoError(t, err)
+	total := 0
+	for _, count := range counts {
+		total += count.Reports
+	}
+	require.Equal(t, 2, total)
+
+	// reports built before sizes were recorded are backfilled
+	unsized, err := reportStore.Create(ctx, user.Id, "monsters", store.ReportOptions{})
+	require.NoError(t, err)
+	outputPath := "users/" + user.Id.String() + "/reports/" + unsized.Id.String() + ".csv.gz"
+	unsized.CompletedAt = &completedAt
+	unsized.OutputFilePath = &outputPath
+	_, err = reportStore.Update(ctx, unsized)
+	require.NoError(t, err)
+
+	backfill, err := reportStore.WithoutOutputBytes(ctx, uuid.Nil, 10)
+	require.NoError(t, err)
+	require.Len(t, backfill, 1)
+	require.Equal(t, unsized.Id, backfill[0].Id)
+
+	require.NoError(t, reportStore.SetOutputBytes(ctx, user.Id, unsized.Id, 2048))
+	usage, err = reportStore.Usage(ctx, user.Id, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, int64(3072), usage.OutputBytes)
+
+	backfill, err = reportStore.WithoutOutputBytes(ctx, uuid.Nil, 10)
+	require.NoError(t, err)
+	require.Empty(t, backfill)
+}
+
+func TestReportStoreList(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+
+	reportStore := store.NewReportStore(env.Db)
+	userStore := store.NewUserStore(env.Db)
+
+	user, err := userStore.Create(ctx, "test@testemail.com", "testPassword")
+	require.NoError(t, err)
+	other, err := userStore.Create(ctx, "other@testemail.com", "testPassword")
+	require.NoError(t, err)
+
+	message := func(*store.Report) (string, error) { return "{}", nil }
+
+	var created []*store.Report
+	for i, labels := range [][]string{{"weekly"}, {"weekly", "eu"}, nil, {"eu"}, {"weekly"}} {
+		reportType := "monsters"
+		if i%2 == 1 {
+			reportType = "food"
+		}
+		report, err := reportStore.CreateAndEnqueue(ctx, user.Id, reportType, store.ReportOptions{}, labels, message)
+		require.NoError(t, err)
+		created = append(created, report)
+	}
+
+	_, err = reportStore.CreateAndEnqueue(ctx, other.Id, "monsters", store.ReportOptions{}, []string{"weekly"}, message)
+	require.NoError(t, err)
+
+	now := time.Now()
+	created[0].StartedAt = &now
+	created[0].CompletedAt = &now
+	_, err = reportStore.Update(ctx, created[0])
+	require.NoError(t, err)
+
+	ids := func(reports []store.Report) []string {
+		var ids []string
+		for _, report := range reports {
+			ids = append(ids, report.Id.String())
+		}
+		return ids
+	}
+
+	// pages through the newest first, the other user's report never shows up
+	var listed []store.Report
+	filter := store.ReportFilter{Limit: 2}
+	for {
+		page, next, err := reportStore.List(ctx, user.Id, filter)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page), 2)
+		listed = append(listed, page...)
+		if next == nil {
+			break
+		}
+		filter.After = next
+	}
+	require.Equal(t, []string{created[4].Id.String(), created[3].Id.String(), created[2].Id.String(), created[1].Id.String(), created[0].Id.String()}, ids(listed))
+
+	page, next, err := reportStore.List(ctx, user.Id, store.ReportFilter{Ascending: true, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{created[0].Id.String(), created[1].Id.String()}, ids(page))
+	require.NotNil(t, next)
+
+	page, _, err = reportStore.List(ctx, user.Id, store.ReportFilter{Ascending: true, After: next, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{created[2].Id.String(), created[3].Id.String()}, ids(page))
+
+	page, next, err = reportStore.List(ctx, user.Id, store.ReportFilter{Labels: []string{"weekly", "eu"}, Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []string{created[1].Id.String()}, ids(page))
+	require.Nil(t, next)
+
+	page, _, err = reportStore.List(ctx, user.Id, store.ReportFilter{ReportType: "food", Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []string{created[3].Id.String(), created[1].Id.String()}, ids(page))
+
+	page, _, err = reportStore.List(ctx, user.Id, store.ReportFilter{Status: "completed", Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []string{created[0].Id.String()}, ids(page))
+
+	page, _, err = reportStore.List(ctx, user.Id, store.ReportFilter{Status: "pending", CreatedAfter: &created[2].CreatedAt, Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []string{created[4].Id.String(), created[3].Id.String()}, ids(page))
+
+	// a cursor only continues a listing with the same sort
+	_, next, err = reportStore.List(ctx, user.Id, store.ReportFilter{Limit: 1})
+	require.NoError(t, err)
+	_, _, err = reportStore.List(ctx, user.Id, store.ReportFilter{Ascending: true, After: next, Limit: 1})
+	require.ErrorIs(t, err, store.ErrCursorSortMismatch)
+
+	// no limit lists a default page
+	page, next, err = reportStore.List(ctx, user.Id, store.ReportFilter{})
+	require.NoError(t, err)
+	require.Len(t, page, len(created))
+	require.Nil(t, next)
+
+	_, _, err = reportStore.List(ctx, user.Id, store.ReportFilter{Status: "unknown", Limit: 10})
+	require.Error(t, err)
 }
@@ -6,6 +6,11 @@ type Store struct {
 	Users        *UserStore
 	RefreshToken *RefreshTokenStore
 	Reports      *ReportStore
+	DataSources  *DataSourceStore
+	Jobs         *JobStore
+	DeadLetters  *DeadLetterStore
+	Outbox       *OutboxStore
+	DataKeys     *DataKeyStore
 }
 
 func New(db *sql.DB) *Store {
@@ -13,5 +18,10 @@ func New(db *sql.DB) *Store {
 		Users:        NewUserStore(db),
 		RefreshToken: NewRefreshTokenStore(db),
 		Reports:      NewReportStore(db),
+		DataSources:  NewDataSourceStore(db),
+		Jobs:         NewJobStore(db),
+		DeadLetters:  NewDeadLetterStore(db),
+		Outbox:       NewOutboxStore(db),
+		DataKeys:     NewDataKeyStore(db),
 	}
 }